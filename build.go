@@ -2,9 +2,13 @@ package prospect
 
 import (
 	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
 	"io"
 	"os"
 	"path/filepath"
+	"strings"
 
 	"github.com/midbel/toml"
 )
@@ -31,47 +35,219 @@ func OpenFile(file string) (io.ReadCloser, error) {
 	return &rc, nil
 }
 
-type RunFunc func(Builder, Data)
+type RunFunc func(Builder, Data) error
 
 type AcceptFunc func(Data) bool
 
 type Builder struct {
 	Include string `toml:"include"`
+	State   string `toml:"state"`
+	Cache   string `toml:"cache"`
 	Archive
 	Context
-	Mimes    MimeSet   `toml:"mimetype"`
-	Commands []Command `toml:"command"`
-	Data     []Data    `toml:"file"`
+	Mimes    MimeSet    `toml:"mimetype"`
+	Commands []Command  `toml:"command"`
+	Data     []Data     `toml:"file"`
+	Rules    []LinkRule `toml:"link"`
+
+	ctx     context.Context
+	journal *journal
+	graph   *graph
 }
 
 func Build(file string, run RunFunc, accept AcceptFunc) error {
+	return BuildContext(context.Background(), file, run, accept)
+}
+
+func BuildContext(ctx context.Context, file string, run RunFunc, accept AcceptFunc) error {
 	b, err := Load(file)
 	if err != nil {
-		return err
+		return fmt.Errorf("%w: %s", ErrConfig, err)
 	}
+	if b.State != "" {
+		if b.journal, err = openJournal(b.State); err != nil {
+			return err
+		}
+		defer b.journal.Close()
+	}
+	b.ctx = ctx
+	b.graph = newGraph()
+	b.graph.restore(b.journal.pending())
 	if accept == nil {
 		accept = func(_ Data) bool { return true }
 	}
+	var es Errors
 	for _, d := range b.Data {
+		if err := ctx.Err(); err != nil {
+			es = append(es, err)
+			break
+		}
 		if d.Type == "" && d.Mime == "" && len(b.Mimes) == 0 {
 			continue
 		}
 		if !accept(d) {
 			continue
 		}
-		run(b, b.Update(d))
+		if err := run(b, b.Update(d)); err != nil {
+			es = append(es, fmt.Errorf("%s: %w", d.File, err))
+			if errors.Is(err, ErrAbort) || ctx.Err() != nil {
+				break
+			}
+		}
 	}
-	return nil
+	if err := ctx.Err(); err != nil {
+		// links are not updated from a partial run: the relations are kept in
+		// the state to be flushed by the run resuming it
+		if err := b.journal.Save(b.graph.pending()); err != nil {
+			es = append(es, err)
+		}
+		if !errors.Is(es, err) {
+			es = append(es, err)
+		}
+		return es.Err()
+	}
+	if err := b.graph.Apply(ctx, b.Archive, b.Rules); err != nil {
+		es = append(es, err)
+	}
+	if err := b.graph.Flush(b.Archive); err != nil {
+		es = append(es, err)
+	}
+	if err := b.journal.Flushed(); err != nil {
+		es = append(es, err)
+	}
+	return es.Err()
+}
+
+type Errors []error
+
+func (es Errors) Err() error {
+	if len(es) == 0 {
+		return nil
+	}
+	return es
+}
+
+func (es Errors) Error() string {
+	str := make([]string, len(es))
+	for i := range es {
+		str[i] = es[i].Error()
+	}
+	return strings.Join(str, "; ")
+}
+
+func (es Errors) Is(target error) bool {
+	for _, e := range es {
+		if errors.Is(e, target) {
+			return true
+		}
+	}
+	return false
+}
+
+func (es Errors) As(target interface{}) bool {
+	for _, e := range es {
+		if errors.As(e, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// Unwrap gives the first error. Use Is and As to check all the errors.
+func (es Errors) Unwrap() error {
+	if len(es) == 0 {
+		return nil
+	}
+	return es[0]
+}
+
+func (b Builder) Walk(dir string, fn filepath.WalkFunc) error {
+	return Walk(b.context(), dir, func(file string, i os.FileInfo, err error) error {
+		if err == nil && !i.IsDir() && b.journal.Has(file) {
+			return nil
+		}
+		return fn(file, i, err)
+	})
 }
 
 func (b Builder) Store(d Data) error {
+	_, err := b.StoreData(d)
+	return err
+}
+
+// StoreData stores d like Store and gives d as stored in the archive: with
+// the version found for it when it is versioned, so that its Path is the path
+// of the product in the archive.
+func (b Builder) StoreData(d Data) (Data, error) {
 	d = b.Context.update(d)
-	return b.Archive.Store(d)
+	ks, ws, err := b.executeCommands(d)
+	if err != nil {
+		return d, err
+	}
+	d.warnings = append(d.warnings, ws...)
+	d.Links = append(d.Links[:len(d.Links):len(d.Links)], ks...)
+	x, path, err := b.Archive.store(d)
+	if err != nil {
+		return d, err
+	}
+	b.graph.Register(d, x, path)
+	if err := b.derive(x); err != nil {
+		return x, err
+	}
+	return x, b.journal.Add(d.File)
+}
+
+// derive creates the products configured with the derive option of the file
+// section of d. Products whose content can not be handled by a deriver are
+// silently skipped.
+func (b Builder) derive(d Data) error {
+	for _, v := range d.Derivers {
+		x, buf, err := v.Derive(d)
+		if errors.Is(err, errUnsupported) {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %w", v.Kind, err)
+		}
+		x.Links = append(x.Links, CreateLinkFrom(d))
+		if _, err := b.CreateFile(x, buf); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
 func (b Builder) CreateFile(d Data, buf []byte) (Link, error) {
 	d = b.Context.update(d)
-	return b.Archive.CreateFile(d, buf)
+	x, path, err := b.Archive.createFile(d, buf)
+	if err != nil {
+		return Link{}, err
+	}
+	b.graph.Register(d, x, path)
+	return CreateLink(path, ""), nil
+}
+
+func (b Builder) CopyFile(d Data, file string) (Link, error) {
+	d = b.Context.update(d)
+	x, path, err := b.Archive.copyData(d, file)
+	if err != nil {
+		return Link{}, err
+	}
+	b.graph.Register(d, x, path)
+	return CreateLink(path, ""), nil
+}
+
+// Relate records that to is related to from with the given role. Both
+// products reference each other once the run is done.
+func (b Builder) Relate(from, to Data, role string) {
+	b.graph.Relate(from, to, role)
+}
+
+func (b Builder) context() context.Context {
+	if b.ctx == nil {
+		return context.Background()
+	}
+	return b.ctx
 }
 
 func (b Builder) GetMime(d Data) Data {
@@ -89,23 +265,46 @@ func (b Builder) GetMime(d Data) Data {
 }
 
 func (b Builder) ExecuteCommands(d Data) ([]Link, error) {
-	var ks []Link
+	ks, _, err := b.executeCommands(d)
+	return ks, err
+}
+
+// executeCommands runs the commands accepting d. The failures of the commands
+// whose exit policy is not fail are returned as warnings.
+func (b Builder) executeCommands(d Data) ([]Link, []error, error) {
+	var (
+		ctx = b.context()
+		ks  []Link
+		ws  []error
+	)
 	for _, c := range b.Commands {
-		x, buf, err := c.Exec(d)
-		if err != nil || len(buf) == 0 {
+		if !c.accept(d) {
 			continue
 		}
-		x.Links = append(x.Links, CreateLinkFrom(d))
-		k, err := b.CreateFile(x, buf)
+		out, err := c.execute(ctx, d, b.Cache)
 		if err != nil {
+			if ctx.Err() != nil || strings.EqualFold(c.Exit, ExitFail) {
+				return ks, ws, err
+			}
+			ws = append(ws, fmt.Errorf("%s: %w", c.name(), err))
 			continue
 		}
-		if k.Role == "" {
-			k.Role = TypeCommand
+		for _, f := range out.Files {
+			x := f.Data
+			x.Links = append(x.Links, CreateLinkFrom(d))
+			k, err := b.CopyFile(x, f.File)
+			if err != nil {
+				out.Close()
+				return ks, ws, fmt.Errorf("%s: %w", c.name(), err)
+			}
+			if k.Role = TypeCommand; x.Type == TypeCommandError {
+				k.Role = TypeCommandError
+			}
+			ks = append(ks, k)
 		}
-		ks = append(ks, k)
+		out.Close()
 	}
-	return ks, nil
+	return ks, ws, nil
 }
 
 func Load(file string) (Builder, error) {
@@ -128,6 +327,43 @@ func Load(file string) (Builder, error) {
 		b.Archive = c.Archive
 		b.Context = c.Context
 	}
+	switch strings.ToLower(b.Duplicate) {
+	case "", PolicyOverride, PolicyKeep, PolicyError:
+	default:
+		return b, fmt.Errorf("%s: unknown duplicate policy", b.Duplicate)
+	}
+	if err := normalizeParameters(b.Metadata); err != nil {
+		return b, err
+	}
+	for _, d := range b.Data {
+		if err := normalizeParameters(d.Parameters); err != nil {
+			return b, err
+		}
+		for _, v := range d.Derivers {
+			if err := v.validate(); err != nil {
+				return b, err
+			}
+		}
+	}
+	names := make(map[string]bool)
+	for _, c := range b.Commands {
+		if err := c.validate(); err != nil {
+			return b, err
+		}
+		names[c.name()] = true
+	}
+	for _, d := range b.Data {
+		for _, n := range d.Commands {
+			if !names[n] {
+				return b, fmt.Errorf("%s: unknown command", n)
+			}
+		}
+	}
+	for i := range b.Rules {
+		if err := b.Rules[i].compile(); err != nil {
+			return b, err
+		}
+	}
 	return b, nil
 }
 
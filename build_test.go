@@ -0,0 +1,148 @@
+package prospect
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestBuildLinks(t *testing.T) {
+	dir, config := makeBuild(t, "")
+	defer os.RemoveAll(dir)
+
+	var (
+		difflog = filepath.Join(dir, "diff.log")
+		check   = checkLinks(t, dir)
+	)
+	if err := BuildContext(context.Background(), config, storeFiles, nil); err != nil {
+		t.Fatalf("first run: %s", err)
+	}
+	check("b.txt", map[string]string{"a.txt": "data"})
+	check("a.txt", map[string]string{"b.txt": "text"})
+	if _, err := os.Stat(difflog); !os.IsNotExist(err) {
+		t.Errorf("first run: difflog should not be written (%v)", err)
+	}
+
+	var a Archive
+	a.DataDir, a.MetaDir, a.DiffLog = filepath.Join(dir, "data"), filepath.Join(dir, "meta"), difflog
+	if _, err := a.Supersede("a.txt", filepath.Join(dir, "in", "a_v2.txt")); err != nil {
+		t.Fatal(err)
+	}
+	i, err := os.Stat(difflog)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := BuildContext(context.Background(), config, storeFiles, nil); err != nil {
+		t.Fatalf("second run: %s", err)
+	}
+	check("b.txt", map[string]string{"a.txt": "data"})
+	check("a.txt", map[string]string{"b.txt": "text", "a_v2.txt": RoleSupersededBy})
+	if x, err := os.Stat(difflog); err != nil || x.Size() != i.Size() {
+		t.Errorf("second run: difflog should not be written (%v)", err)
+	}
+}
+
+func TestBuildResume(t *testing.T) {
+	dir, config := makeBuild(t, "state = \"%[1]s/state\"\n")
+	defer os.RemoveAll(dir)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	run := func(b Builder, d Data) error {
+		err := storeFiles(b, d)
+		if filepath.Base(d.File) == "b" {
+			cancel()
+		}
+		return err
+	}
+	if err := BuildContext(ctx, config, run, nil); !errors.Is(err, context.Canceled) {
+		t.Fatalf("first run: want %s, got %v", context.Canceled, err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "meta", "a.txt.xml")); !os.IsNotExist(err) {
+		t.Fatalf("first run: a.txt should not be stored (%v)", err)
+	}
+	if err := BuildContext(context.Background(), config, storeFiles, nil); err != nil {
+		t.Fatalf("second run: %s", err)
+	}
+	check := checkLinks(t, dir)
+	check("b.txt", map[string]string{"a.txt": "data"})
+	check("a.txt", map[string]string{"b.txt": "text"})
+}
+
+func makeBuild(t *testing.T, extra string) (string, string) {
+	t.Helper()
+	dir, err := ioutil.TempDir("", "prospect-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for file, content := range map[string]string{"a/a.txt": "a", "b/b.txt": "b", "a_v2.txt": "a2"} {
+		file = filepath.Join(dir, "in", file)
+		if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(file, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	str := `datadir = "%[1]s/data"
+metadir = "%[1]s/meta"
+no-overwrite = true
+difflog = "%[1]s/diff.log"
+` + extra + `[[file]]
+file = "%[1]s/in/b"
+type = "text"
+mime = "text/plain"
+[[file.links]]
+file = "a.txt"
+role = "data"
+[[file]]
+file = "%[1]s/in/a"
+type = "text"
+mime = "text/plain"
+`
+	config := filepath.Join(dir, "config.toml")
+	if err := ioutil.WriteFile(config, []byte(fmt.Sprintf(str, dir)), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return dir, config
+}
+
+func storeFiles(b Builder, d Data) error {
+	return b.Walk(d.File, func(file string, i os.FileInfo, err error) error {
+		if err != nil || i.IsDir() {
+			return err
+		}
+		x := d.Clone()
+		if err := ReadFile(&x, file); err != nil {
+			return err
+		}
+		return b.Store(x)
+	})
+}
+
+func checkLinks(t *testing.T, dir string) func(string, map[string]string) {
+	return func(file string, want map[string]string) {
+		t.Helper()
+		d, err := DecodeFile(filepath.Join(dir, "meta", file+".xml"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := make(map[string]string)
+		for _, k := range d.Links {
+			got[k.File] = k.Role
+		}
+		if len(got) != len(want) {
+			t.Errorf("%s: want %d links, got %d (%v)", file, len(want), len(got), got)
+		}
+		for k, r := range want {
+			if got[k] != r {
+				t.Errorf("%s: link %s: want role %q, got %q", file, k, r, got[k])
+			}
+		}
+	}
+}
@@ -0,0 +1,72 @@
+package prospect
+
+import (
+	"context"
+)
+
+type Product struct {
+	Path string
+	Data Data
+}
+
+type Record struct {
+	Path string `json:"path"`
+	Entry
+	Model    string      `json:"model"`
+	Source   string      `json:"source"`
+	Owner    string      `json:"owner"`
+	Crews    []string    `json:"crews"`
+	Metadata []Parameter `json:"metadata"`
+	Links    []Link      `json:"links"`
+}
+
+func (p Product) Record() Record {
+	return Record{
+		Path:     p.Path,
+		Entry:    NewEntry(p.Data),
+		Model:    p.Data.Model,
+		Source:   p.Data.Source,
+		Owner:    p.Data.Owner,
+		Crews:    p.Data.Crews,
+		Metadata: p.Data.Parameters,
+		Links:    p.Data.Links,
+	}
+}
+
+type Catalog struct {
+	Products []Product
+	index    map[string]int
+}
+
+func LoadCatalog(ctx context.Context, dir string) (*Catalog, error) {
+	c := Catalog{
+		index: make(map[string]int),
+	}
+	err := WalkMeta(ctx, dir, func(file string, d Data) error {
+		c.index[file] = len(c.Products)
+		if _, ok := c.index[d.File]; !ok {
+			c.index[d.File] = len(c.Products)
+		}
+		c.Products = append(c.Products, Product{Path: file, Data: d})
+		return nil
+	})
+	return &c, err
+}
+
+func (c *Catalog) Get(file string) (Product, bool) {
+	i, ok := c.index[file]
+	if !ok {
+		return Product{}, ok
+	}
+	return c.Products[i], ok
+}
+
+func (c *Catalog) Find(f Filter) []Product {
+	var ps []Product
+	for _, p := range c.Products {
+		if f == nil || f.Match(p.Data) {
+			ps = append(ps, p)
+		}
+	}
+	return ps
+}
@@ -1,7 +1,14 @@
 package trace
 
 import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"flag"
 	"fmt"
+	"io"
 	"log"
 	"os"
 	"path/filepath"
@@ -10,50 +17,283 @@ import (
 	"github.com/busoc/prospect"
 )
 
+var (
+	report    = flag.String("report", "", "write events of the run as JSON lines to file")
+	maxErrors = flag.Uint64("max-errors", 0, "abort the run after the given number of errors")
+	failFast  = flag.Bool("fail-fast", false, "abort the run at the first error")
+)
+
+const (
+	ExitOK = iota
+	ExitFatal
+	ExitConfig
+	ExitPartial
+	ExitAbort
+	ExitInterrupt
+)
+
+const (
+	EventStart   = "start"
+	EventDone    = "done"
+	EventError   = "error"
+	EventSkip    = "skip"
+	EventWarning = "warning"
+	EventSummary = "summary"
+)
+
+const (
+	ClassIgnore     = "ignore"
+	ClassDuplicate  = "duplicate"
+	ClassNotFound   = "not-found"
+	ClassPermission = "permission"
+	ClassExist      = "exist"
+	ClassFormat     = "format"
+	ClassTruncated  = "truncated"
+	ClassCanceled   = "canceled"
+	ClassUnknown    = "unknown"
+)
+
+type Event struct {
+	Time    time.Time `json:"time"`
+	Command string    `json:"command"`
+	Event   string    `json:"event"`
+	File    string    `json:"file,omitempty"`
+	Archive string    `json:"archive,omitempty"`
+	Size    int64     `json:"size,omitempty"`
+	Elapsed float64   `json:"elapsed,omitempty"`
+	Class   string    `json:"class,omitempty"`
+	Error   string    `json:"error,omitempty"`
+}
+
+type Summary struct {
+	Time    time.Time      `json:"time"`
+	Command string         `json:"command"`
+	Event   string         `json:"event"`
+	Starts  time.Time      `json:"starts"`
+	Elapsed float64        `json:"elapsed"`
+	Files   uint64         `json:"files"`
+	Done    uint64         `json:"done"`
+	Skipped uint64         `json:"skipped"`
+	Warned  uint64         `json:"warnings"`
+	Errors  uint64         `json:"errors"`
+	Size    int64          `json:"size"`
+	Classes map[string]int `json:"classes,omitempty"`
+}
+
 type Tracer struct {
-	now    time.Time
+	name   string
 	logger *log.Logger
+	events io.WriteCloser
+	starts map[string]time.Time
 
-	err   uint64
-	files uint64
-	size  float64
-	when  time.Time
+	err     uint64
+	files   uint64
+	done    uint64
+	skip    uint64
+	warn    uint64
+	size    int64
+	when    time.Time
+	classes map[string]int
 }
 
-func New(name string) *Tracer {
-	name = fmt.Sprintf("[%s] ", name)
+func New(name string) (*Tracer, error) {
 	t := Tracer{
-		logger: log.New(os.Stdout, name, log.LstdFlags),
-		when:   time.Now(),
+		name:    name,
+		logger:  log.New(os.Stdout, fmt.Sprintf("[%s] ", name), log.LstdFlags),
+		starts:  make(map[string]time.Time),
+		classes: make(map[string]int),
+		when:    time.Now(),
+	}
+	if *report != "" {
+		if err := os.MkdirAll(filepath.Dir(*report), 0755); err != nil {
+			return nil, err
+		}
+		w, err := os.OpenFile(*report, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			return nil, err
+		}
+		t.events = w
 	}
-	return &t
+	return &t, nil
 }
 
 func (t *Tracer) Start(file string) {
-	t.now = time.Now()
+	t.starts[file] = time.Now()
 	t.files++
 	t.Trace("start processing %s", file)
+	t.emit(Event{
+		Event: EventStart,
+		File:  file,
+	})
 }
 
 func (t *Tracer) Summarize() {
 	elapsed := time.Since(t.when)
-	t.Trace("%d files processed (%s - %.0f - %d errors)", t.files, elapsed, t.size, t.err)
+	t.Trace("%d files processed (%s - %d bytes - %d errors)", t.files, elapsed, t.size, t.err)
+	if t.events == nil {
+		return
+	}
+	s := Summary{
+		Time:    time.Now().UTC(),
+		Command: t.name,
+		Event:   EventSummary,
+		Starts:  t.when.UTC(),
+		Elapsed: elapsed.Seconds(),
+		Files:   t.files,
+		Done:    t.done,
+		Skipped: t.skip,
+		Warned:  t.warn,
+		Errors:  t.err,
+		Size:    t.size,
+		Classes: t.classes,
+	}
+	if err := json.NewEncoder(t.events).Encode(s); err != nil {
+		t.Trace("fail to write summary: %s", err)
+	}
+	t.events.Close()
+	t.events = nil
 }
 
 func (t *Tracer) Done(file string, d prospect.Data) {
 	var (
-		elapsed = time.Since(t.now)
-		archive = filepath.Join(d.Resolve(), filepath.Base(d.File))
+		elapsed = t.elapsed(file)
+		archive = d.Path()
 	)
-	t.size += float64(d.Size)
+	t.done++
+	t.size += d.Size
 	t.Trace("done processing %s -> %s (%d, %s)", file, archive, d.Size, elapsed)
+	t.emit(Event{
+		Event:   EventDone,
+		File:    file,
+		Archive: archive,
+		Size:    d.Size,
+		Elapsed: elapsed.Seconds(),
+	})
+	for _, err := range d.Warnings() {
+		t.Warn(file, err)
+	}
 }
 
-func (t *Tracer) Error(file string, err error) {
-	t.err++
-	t.Trace("error while processing %s: %s", file, err)
+// Warn reports an error that did not prevent file from being processed.
+func (t *Tracer) Warn(file string, err error) {
+	t.warn++
+	t.Trace("warning while processing %s: %s", file, err)
+	t.emit(Event{
+		Event: EventWarning,
+		File:  file,
+		Class: Classify(err),
+		Error: err.Error(),
+	})
+}
+
+func (t *Tracer) Error(file string, err error) error {
+	var (
+		elapsed = t.elapsed(file)
+		class   = Classify(err)
+		event   = EventError
+	)
+	if class == ClassIgnore {
+		t.skip++
+		event = EventSkip
+		t.Trace("skip processing %s: %s", file, err)
+	} else {
+		t.err++
+		t.classes[class]++
+		t.Trace("error while processing %s: %s", file, err)
+	}
+	t.emit(Event{
+		Event:   event,
+		File:    file,
+		Elapsed: elapsed.Seconds(),
+		Class:   class,
+		Error:   err.Error(),
+	})
+	return t.Err()
+}
+
+func (t *Tracer) Err() error {
+	if t.err == 0 {
+		return nil
+	}
+	if *failFast || (*maxErrors > 0 && t.err >= *maxErrors) {
+		return fmt.Errorf("%w: %d errors", prospect.ErrAbort, t.err)
+	}
+	return nil
+}
+
+func (t *Tracer) Exit(err error) {
+	t.Summarize()
+	if err == nil && t.err > 0 {
+		err = fmt.Errorf("%w: %d/%d files in error", prospect.ErrPartial, t.err, t.files)
+	}
+	code := ExitOK
+	switch {
+	case err == nil:
+	case errors.Is(err, context.Canceled):
+		code = ExitInterrupt
+	case errors.Is(err, prospect.ErrConfig):
+		code = ExitConfig
+	case errors.Is(err, prospect.ErrAbort):
+		code = ExitAbort
+	case errors.Is(err, prospect.ErrPartial):
+		code = ExitPartial
+	default:
+		code = ExitFatal
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+	}
+	os.Exit(code)
 }
 
 func (t *Tracer) Trace(msg string, args ...interface{}) {
 	t.logger.Printf(msg, args...)
 }
+
+func (t *Tracer) elapsed(file string) time.Duration {
+	when, ok := t.starts[file]
+	if !ok {
+		return 0
+	}
+	delete(t.starts, file)
+	return time.Since(when)
+}
+
+func (t *Tracer) emit(e Event) {
+	if t.events == nil {
+		return
+	}
+	e.Time = time.Now().UTC()
+	e.Command = t.name
+	if err := json.NewEncoder(t.events).Encode(e); err != nil {
+		t.Trace("fail to write event: %s", err)
+	}
+}
+
+func Classify(err error) string {
+	var (
+		errXML  *xml.SyntaxError
+		errJSON *json.SyntaxError
+		errCSV  *csv.ParseError
+	)
+	switch {
+	case errors.Is(err, prospect.ErrIgnore):
+		return ClassIgnore
+	case errors.Is(err, prospect.ErrDuplicate):
+		return ClassDuplicate
+	case errors.Is(err, context.Canceled):
+		return ClassCanceled
+	case errors.Is(err, os.ErrNotExist):
+		return ClassNotFound
+	case errors.Is(err, os.ErrPermission):
+		return ClassPermission
+	case errors.Is(err, os.ErrExist), errors.Is(err, prospect.ErrOverwrite):
+		return ClassExist
+	case errors.Is(err, io.ErrUnexpectedEOF):
+		return ClassTruncated
+	case errors.As(err, &errXML), errors.As(err, &errJSON), errors.As(err, &errCSV):
+		return ClassFormat
+	default:
+		return ClassUnknown
+	}
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+
+	"github.com/busoc/prospect"
+)
+
+func main() {
+	full := flag.Bool("j", false, "print full records as JSON")
+	flag.Parse()
+
+	b, err := prospect.Load(flag.Arg(0))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	filter, err := prospect.ParseFilter(strings.Join(flag.Args()[1:], " "))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+
+	c, err := prospect.LoadCatalog(ctx, b.MetaDir)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	var (
+		ws = bufio.NewWriter(os.Stdout)
+		e  = json.NewEncoder(ws)
+	)
+	defer ws.Flush()
+	for _, p := range c.Find(filter) {
+		if *full {
+			err = e.Encode(p.Record())
+		} else {
+			_, err = fmt.Fprintln(ws, p.Data.File)
+		}
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+	}
+}
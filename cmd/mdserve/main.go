@@ -0,0 +1,198 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/busoc/prospect"
+)
+
+const (
+	MimeJSON = "application/json"
+	MimeXML  = "application/xml"
+)
+
+func main() {
+	var (
+		addr    = flag.String("a", "localhost:8080", "listening address")
+		refresh = flag.Duration("r", 0, "reload the catalogue periodically")
+	)
+	flag.Parse()
+
+	b, err := prospect.Load(flag.Arg(0))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+
+	s := server{
+		datadir: b.DataDir,
+		metadir: b.MetaDir,
+	}
+	if err := s.Reload(ctx); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	if *refresh > 0 {
+		go s.Refresh(ctx, *refresh)
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/products", s.listProducts)
+	mux.Handle("/meta/", http.StripPrefix("/meta/", http.HandlerFunc(s.getMeta)))
+	mux.Handle("/data/", http.StripPrefix("/data/", http.HandlerFunc(s.getData)))
+	mux.Handle("/links/", http.StripPrefix("/links/", http.HandlerFunc(s.getLinks)))
+
+	srv := http.Server{
+		Addr:    *addr,
+		Handler: readOnly(mux),
+	}
+	go func() {
+		<-ctx.Done()
+		c, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		srv.Shutdown(c)
+	}()
+	log.Printf("serving catalogue of %s on %s", b.MetaDir, *addr)
+	if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+type server struct {
+	datadir string
+	metadir string
+
+	mu      sync.RWMutex
+	catalog *prospect.Catalog
+}
+
+func (s *server) Reload(ctx context.Context) error {
+	c, err := prospect.LoadCatalog(ctx, s.metadir)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.catalog = c
+	log.Printf("%d products loaded", len(c.Products))
+	return nil
+}
+
+func (s *server) Refresh(ctx context.Context, every time.Duration) {
+	tick := time.NewTicker(every)
+	defer tick.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-tick.C:
+			if err := s.Reload(ctx); err != nil {
+				log.Printf("fail to reload catalogue: %s", err)
+			}
+		}
+	}
+}
+
+func (s *server) listProducts(w http.ResponseWriter, r *http.Request) {
+	filter, err := prospect.ParseFilter(r.URL.Query().Get("q"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	ps := s.current().Find(filter)
+	rs := make([]prospect.Record, len(ps))
+	for i := range ps {
+		rs[i] = ps[i].Record()
+	}
+	writeJSON(w, rs)
+}
+
+func (s *server) getMeta(w http.ResponseWriter, r *http.Request) {
+	p, ok := s.current().Get(r.URL.Path)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	if acceptXML(r) {
+		w.Header().Set("content-type", MimeXML)
+		http.ServeFile(w, r, filepath.Join(s.metadir, p.Path+".xml"))
+		return
+	}
+	writeJSON(w, p.Record())
+}
+
+func (s *server) getData(w http.ResponseWriter, r *http.Request) {
+	p, ok := s.current().Get(r.URL.Path)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	if p.Data.Mime != "" {
+		w.Header().Set("content-type", p.Data.Mime)
+	}
+	http.ServeFile(w, r, filepath.Join(s.datadir, p.Path))
+}
+
+func (s *server) getLinks(w http.ResponseWriter, r *http.Request) {
+	c := s.current()
+	p, ok := c.Get(r.URL.Path)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	rs := make([]prospect.Record, 0, len(p.Data.Links))
+	for _, k := range p.Data.Links {
+		if x, ok := c.Get(k.File); ok {
+			rs = append(rs, x.Record())
+		}
+	}
+	writeJSON(w, rs)
+}
+
+func (s *server) current() *prospect.Catalog {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.catalog
+}
+
+func readOnly(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			w.Header().Set("allow", "GET, HEAD")
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+func acceptXML(r *http.Request) bool {
+	if f := r.URL.Query().Get("format"); f != "" {
+		return f == "xml"
+	}
+	return strings.Contains(r.Header.Get("accept"), MimeXML)
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("content-type", MimeJSON)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("fail to write response: %s", err)
+	}
+}
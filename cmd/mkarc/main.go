@@ -9,7 +9,10 @@ import (
 	"log"
 	"os"
 	"os/exec"
+	"os/signal"
 	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
 
 	"github.com/midbel/toml"
@@ -52,23 +55,26 @@ type Cmd struct {
 	Post []Cmd `toml:"post"`
 }
 
-func (c Cmd) Exec() error {
-	if err := execCmd(c.Pre...); err != nil {
+func (c Cmd) Exec(ctx context.Context) error {
+	if err := execCmd(ctx, c.Pre...); err != nil {
 		return err
 	}
-	if err := execCmd(c); err != nil {
+	if err := execCmd(ctx, c); err != nil {
 		return err
 	}
-	return execCmd(c.Post...)
+	return execCmd(ctx, c.Post...)
 }
 
-func (c Cmd) Run() error {
+func (c Cmd) Run(ctx context.Context) error {
 	var (
 		args = append(c.Args, c.File)
 		cmd  = exec.Command(c.Path, args...)
 	)
+	if len(c.Env) > 0 {
+		cmd.Env = os.Environ()
+	}
 	for i := range c.Env {
-		cmd.Env = append(cmd.Env, fmt.Sprintf("%s=%s"), c.Env[i].Name, c.Env[i].Value)
+		cmd.Env = append(cmd.Env, fmt.Sprintf("%s=%s", c.Env[i].Name, c.Env[i].Value))
 	}
 
 	if !c.Silent {
@@ -79,13 +85,28 @@ func (c Cmd) Run() error {
 		cmd.Stderr = ioutil.Discard
 	}
 
-	return cmd.Run()
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			cmd.Process.Signal(syscall.SIGTERM)
+		case <-done:
+		}
+	}()
+	return cmd.Wait()
 }
 
-func execCmd(cs ...Cmd) error {
+func execCmd(ctx context.Context, cs ...Cmd) error {
 	var err error
 	for _, c := range cs {
-		err = c.Run()
+		if err = ctx.Err(); err != nil {
+			break
+		}
+		err = c.Run(ctx)
 		if err != nil {
 			break
 		}
@@ -108,13 +129,16 @@ func main() {
 	if c.Task == 0 {
 		c.Task = int64(len(c.Commands))
 	}
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+
 	var (
-		ctx  = context.TODO()
-		sema = semaphore.NewWeighted(c.Task)
+		sema   = semaphore.NewWeighted(c.Task)
+		failed int32
 	)
 	for _, c := range c.Commands {
 		if err := sema.Acquire(ctx, 1); err != nil {
-			os.Exit(2)
+			break
 		}
 		go func(c Cmd) {
 			defer func(n time.Time) {
@@ -122,10 +146,19 @@ func main() {
 				log.Printf("done: %s %s (%s)", c.Path, c.File, time.Since(n))
 			}(time.Now())
 			log.Printf("start: %s %s", c.Path, c.File)
-			if err := c.Exec(); err != nil {
-				log.Printf("error: %s", err)
+			if err := c.Exec(ctx); err != nil {
+				atomic.AddInt32(&failed, 1)
+				log.Printf("error: %s %s: %s", c.Path, c.File, err)
 			}
 		}(c)
 	}
-	sema.Acquire(ctx, c.Task)
+	sema.Acquire(context.Background(), c.Task)
+	if err := ctx.Err(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(5)
+	}
+	if failed > 0 {
+		fmt.Fprintf(os.Stderr, "%d/%d commands failed\n", failed, len(c.Commands))
+		os.Exit(1)
+	}
 }
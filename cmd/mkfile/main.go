@@ -1,10 +1,12 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"os"
-	"path/filepath"
+	"os/signal"
+	"syscall"
 
 	"github.com/busoc/prospect"
 	"github.com/busoc/prospect/cmd/internal/trace"
@@ -13,36 +15,39 @@ import (
 func main() {
 	flag.Parse()
 
-	err := prospect.Build(flag.Arg(0), collectData, nil)
+	tracer, err := trace.New("mkfile")
 	if err != nil {
 		fmt.Fprintln(os.Stderr, err)
 		os.Exit(1)
 	}
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	err = prospect.BuildContext(ctx, flag.Arg(0), collectData(tracer), nil)
+	cancel()
+	tracer.Exit(err)
 }
 
-func collectData(b prospect.Builder, d prospect.Data) {
-	tracer := trace.New("mkfile")
-	defer tracer.Summarize()
-	filepath.Walk(d.File, func(file string, i os.FileInfo, err error) error {
-		if err != nil || i.IsDir() {
-			return err
-		}
-		dat := d.Clone()
-		dat.File = file
-
-		tracer.Start(file)
-		defer tracer.Done(file, dat)
-
-		if dat, err = processData(dat); err != nil {
-			tracer.Error(file, err)
+func collectData(tracer *trace.Tracer) prospect.RunFunc {
+	return func(b prospect.Builder, d prospect.Data) error {
+		return b.Walk(d.File, func(file string, i os.FileInfo, err error) error {
+			if err != nil || i.IsDir() {
+				return err
+			}
+			dat := d.Clone()
+			dat.File = file
+
+			tracer.Start(file)
+
+			if dat, err = processData(dat); err != nil {
+				return tracer.Error(file, err)
+			}
+			dat = b.GetMime(dat)
+			if dat, err = b.StoreData(dat); err != nil {
+				return tracer.Error(file, err)
+			}
+			tracer.Done(file, dat)
 			return nil
-		}
-		dat = b.GetMime(dat)
-		if err := b.Store(dat); err != nil {
-			tracer.Error(file, err)
-		}
-		return nil
-	})
+		})
+	}
 }
 
 func processData(d prospect.Data) (prospect.Data, error) {
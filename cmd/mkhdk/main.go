@@ -2,13 +2,16 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"encoding/binary"
 	"encoding/xml"
 	"flag"
 	"fmt"
 	"os"
+	"os/signal"
 	"path/filepath"
 	"strings"
+	"syscall"
 	"time"
 
 	"github.com/busoc/prospect"
@@ -47,11 +50,15 @@ func main() {
 		)
 		return typ == typType && (sub == imgType || sub == scType)
 	}
-	err := prospect.Build(flag.Arg(0), collectData(*skipbad), accept)
+	tracer, err := trace.New("mkhdk")
 	if err != nil {
 		fmt.Fprintln(os.Stderr, err)
 		os.Exit(1)
 	}
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	err = prospect.BuildContext(ctx, flag.Arg(0), collectData(tracer, *skipbad), accept)
+	cancel()
+	tracer.Exit(err)
 }
 
 const (
@@ -59,11 +66,9 @@ const (
 	ExtBad = ".bad"
 )
 
-func collectData(skipbad bool) prospect.RunFunc {
-	return func(b prospect.Builder, d prospect.Data) {
-		tracer := trace.New("mkhdk")
-		defer tracer.Summarize()
-		filepath.Walk(d.File, func(file string, i os.FileInfo, err error) error {
+func collectData(tracer *trace.Tracer, skipbad bool) prospect.RunFunc {
+	return func(b prospect.Builder, d prospect.Data) error {
+		return b.Walk(d.File, func(file string, i os.FileInfo, err error) error {
 			if err != nil || i.IsDir() {
 				return err
 			}
@@ -76,11 +81,10 @@ func collectData(skipbad bool) prospect.RunFunc {
 
 			dat, err = processData(dat, file)
 			if err != nil {
-				tracer.Error(file, err)
-				return nil
+				return tracer.Error(file, err)
 			}
-			if err := b.Store(dat); err != nil {
-				tracer.Error(file, err)
+			if dat, err = b.StoreData(dat); err != nil {
+				return tracer.Error(file, err)
 			}
 			tracer.Done(file, dat)
 			return nil
@@ -181,14 +185,10 @@ func updateDataRaw(d *prospect.Data) error {
 	d.AcqTime = epoch.Add(time.Duration(c.Unix))
 	d.ModTime = epoch.Add(time.Duration(c.Unix))
 
-	fcc := prospect.MakeParameter(fileFCC, strings.TrimSpace(string(c.FCC[:])))
-	d.Parameters = append(d.Parameters, fcc)
+	d.Register(fileFCC, strings.TrimSpace(string(c.FCC[:])))
 	if isImage(c.FCC[:]) {
-		ps := []prospect.Parameter{
-			prospect.MakeParameter(prospect.ImageWidth, c.Width),
-			prospect.MakeParameter(prospect.ImageHeight, c.Height),
-		}
-		d.Parameters = append(d.Parameters, ps...)
+		d.Register(prospect.ImageWidth, c.Width)
+		d.Register(prospect.ImageHeight, c.Height)
 	}
 	return nil
 }
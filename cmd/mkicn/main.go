@@ -2,14 +2,17 @@ package main
 
 import (
 	"bufio"
+	"context"
 	"flag"
 	"fmt"
 	"io"
 	"os"
+	"os/signal"
 	"path/filepath"
 	"regexp"
 	"sort"
 	"strings"
+	"syscall"
 	"time"
 
 	"github.com/busoc/prospect"
@@ -61,19 +64,20 @@ func main() {
 		}
 		return strings.ToLower(mt.Params["type"]) == "icn"
 	}
-	err := prospect.Build(flag.Arg(0), collectData(list.Records), accept)
+	tracer, err := trace.New("mkicn")
 	if err != nil {
 		fmt.Fprintln(os.Stderr, err)
 		os.Exit(1)
 	}
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	err = prospect.BuildContext(ctx, flag.Arg(0), collectData(tracer, list.Records), accept)
+	cancel()
+	tracer.Exit(err)
 }
 
-func collectData(list []string) prospect.RunFunc {
-	return func(b prospect.Builder, d prospect.Data) {
-		tracer := trace.New("mkicn")
-		defer tracer.Summarize()
-
-		filepath.Walk(d.File, func(file string, i os.FileInfo, err error) error {
+func collectData(tracer *trace.Tracer, list []string) prospect.RunFunc {
+	return func(b prospect.Builder, d prospect.Data) error {
+		return b.Walk(d.File, func(file string, i os.FileInfo, err error) error {
 			if err != nil || i.IsDir() || !d.Accept(file) {
 				return err
 			}
@@ -81,16 +85,18 @@ func collectData(list []string) prospect.RunFunc {
 
 			dat, files, err := processConsoleNote(d.Clone(), file, list)
 			if err != nil {
-				tracer.Error(file, err)
-				return nil
+				return tracer.Error(file, err)
 			}
-			links := storeTables(b, files, prospect.CreateLinkFrom(dat))
-			if len(links) == 0 {
-				return nil
+			n, err := storeTables(b, tracer, files, prospect.CreateLinkFrom(dat))
+			if err != nil {
+				tracer.Error(file, fmt.Errorf("%w: %s", prospect.ErrIgnore, err))
+				return err
 			}
-			dat.Links = append(dat.Links, links...)
-			if err := b.Store(dat); err != nil {
-				tracer.Error(file, err)
+			if n == 0 {
+				return tracer.Error(file, fmt.Errorf("%w: no table stored", prospect.ErrIgnore))
+			}
+			if dat, err = b.StoreData(dat); err != nil {
+				return tracer.Error(file, err)
 			}
 			tracer.Done(file, dat)
 			return nil
@@ -98,29 +104,28 @@ func collectData(list []string) prospect.RunFunc {
 	}
 }
 
-func storeTables(b prospect.Builder, files []prospect.Data, link prospect.Link) []prospect.Link {
-	var (
-		links  []prospect.Link
-		tracer = trace.New("mkicn")
-	)
-	defer tracer.Summarize()
-
+func storeTables(b prospect.Builder, tracer *trace.Tracer, files []prospect.Data, link prospect.Link) (int, error) {
+	var count int
 	for _, f := range files {
 		tracer.Start(f.File)
 		f, err := processTable(f)
 		if err != nil {
-			tracer.Error(f.File, err)
+			if err := tracer.Error(f.File, err); err != nil {
+				return count, err
+			}
 			continue
 		}
 		f.Links = append(f.Links, link)
-		if err := b.Store(f); err != nil {
-			tracer.Error(f.File, err)
+		if f, err = b.StoreData(f); err != nil {
+			if err := tracer.Error(f.File, err); err != nil {
+				return count, err
+			}
 			continue
 		}
-		links = append(links, prospect.CreateLinkFrom(f))
+		count++
 		tracer.Done(f.File, f)
 	}
-	return links
+	return count, nil
 }
 
 const (
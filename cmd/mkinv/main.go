@@ -0,0 +1,105 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	"github.com/busoc/prospect"
+)
+
+const (
+	FormatCSV  = "csv"
+	FormatJSON = "json"
+	FormatXML  = "xml"
+)
+
+type writer interface {
+	Write(io.Writer, string) error
+	WriteFileAs(string, string) error
+}
+
+func main() {
+	var (
+		format = flag.String("f", "", "output format (csv, json, xml)")
+		file   = flag.String("o", "", "write inventory to file")
+		stats  = flag.Bool("s", false, "write statistics per type, increment and day")
+		incr   = flag.String("i", "", "comma separated list of increments")
+	)
+	flag.Parse()
+
+	switch *format {
+	case "", FormatCSV, FormatJSON, FormatXML:
+	default:
+		fmt.Fprintf(os.Stderr, "%s: unsupported format\n", *format)
+		os.Exit(2)
+	}
+	b, err := prospect.Load(flag.Arg(0))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+
+	iv, err := prospect.ReadInventory(ctx, b.MetaDir, acceptIncrements(*incr))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	var w writer = iv
+	if *stats {
+		w = iv.Statistics()
+	}
+	if *format == "" {
+		*format = formatOf(*file)
+	}
+	if *file != "" {
+		err = w.WriteFileAs(*file, *format)
+	} else {
+		ws := bufio.NewWriter(os.Stdout)
+		if err = w.Write(ws, *format); err == nil {
+			err = ws.Flush()
+		}
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+// formatOf gives the format matching the extension of file, csv if the
+// extension is not the one of a supported format.
+func formatOf(file string) string {
+	switch f := strings.TrimPrefix(strings.ToLower(filepath.Ext(file)), "."); f {
+	case FormatJSON, FormatXML:
+		return f
+	default:
+		return FormatCSV
+	}
+}
+
+func acceptIncrements(str string) prospect.AcceptFunc {
+	if str == "" {
+		return nil
+	}
+	list := strings.Split(str, ",")
+	return func(d prospect.Data) bool {
+		for _, i := range d.Increments {
+			for _, j := range list {
+				if strings.TrimSpace(j) == i {
+					return true
+				}
+			}
+		}
+		return false
+	}
+}
@@ -1,11 +1,14 @@
 package main
 
 import (
+	"context"
 	"encoding/csv"
 	"flag"
 	"fmt"
 	"os"
-	"path/filepath"
+	"os/signal"
+	"sort"
+	"syscall"
 	"time"
 
 	"github.com/busoc/prospect"
@@ -37,18 +40,20 @@ func main() {
 		}
 		return m.MainType == MainType && m.SubType == SubType
 	}
-	err := prospect.Build(flag.Arg(0), collectData(*between), accept)
+	tracer, err := trace.New("mkmma")
 	if err != nil {
 		fmt.Fprintln(os.Stderr, err)
 		os.Exit(1)
 	}
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	err = prospect.BuildContext(ctx, flag.Arg(0), collectData(tracer, *between), accept)
+	cancel()
+	tracer.Exit(err)
 }
 
-func collectData(between time.Duration) func(prospect.Builder, prospect.Data) {
-	return func(b prospect.Builder, d prospect.Data) {
-		tracer := trace.New("mkmma")
-		defer tracer.Summarize()
-		filepath.Walk(d.File, func(file string, i os.FileInfo, err error) error {
+func collectData(tracer *trace.Tracer, between time.Duration) prospect.RunFunc {
+	return func(b prospect.Builder, d prospect.Data) error {
+		return b.Walk(d.File, func(file string, i os.FileInfo, err error) error {
 			if err != nil || i.IsDir() || !d.Accept(file) {
 				return err
 			}
@@ -57,11 +62,10 @@ func collectData(between time.Duration) func(prospect.Builder, prospect.Data) {
 			tracer.Start(file)
 
 			if dat, err = processData(dat, file, between); err != nil {
-				tracer.Error(file, err)
-				return nil
+				return tracer.Error(file, err)
 			}
-			if err := b.Store(dat); err != nil {
-				tracer.Error(file, err)
+			if dat, err = b.StoreData(dat); err != nil {
+				return tracer.Error(file, err)
 			}
 			tracer.Done(file, dat)
 			return nil
@@ -121,12 +125,16 @@ func readFile(d prospect.Data, between time.Duration) (prospect.Data, error) {
 	d.Register(prospect.FileDuration, time.Duration(count)*between)
 	d.Register(prospect.FileRecord, count)
 
-	var i int
-	for upi, count := range runs {
-		i++
-		d.Register(fmt.Sprintf(scienceRun, i), upi)
-		d.Register(fmt.Sprintf(scienceRec, i), count)
-		d.Register(fmt.Sprintf(scienceDur, i), time.Duration(count)*between)
+	upis := make([]string, 0, len(runs))
+	for upi := range runs {
+		upis = append(upis, upi)
+	}
+	sort.Strings(upis)
+	for i, upi := range upis {
+		count := runs[upi]
+		d.Register(fmt.Sprintf(scienceRun, i+1), upi)
+		d.Register(fmt.Sprintf(scienceRec, i+1), count)
+		d.Register(fmt.Sprintf(scienceDur, i+1), time.Duration(count)*between)
 	}
 	return d, nil
 }
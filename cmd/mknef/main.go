@@ -2,12 +2,15 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"flag"
 	"fmt"
 	"image/jpeg"
 	"os"
+	"os/signal"
 	"path/filepath"
 	"strings"
+	"syscall"
 
 	"github.com/busoc/prospect"
 	"github.com/busoc/prospect/cmd/internal/trace"
@@ -27,64 +30,54 @@ func main() {
 	accept := func(d prospect.Data) bool {
 		return d.Mime == Mime
 	}
-	err := prospect.Build(flag.Arg(0), collectData, accept)
+	tracer, err := trace.New("mknef")
 	if err != nil {
 		fmt.Fprintln(os.Stderr, err)
 		os.Exit(1)
 	}
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	err = prospect.BuildContext(ctx, flag.Arg(0), collectData(tracer), accept)
+	cancel()
+	tracer.Exit(err)
 }
 
-func collectData(b prospect.Builder, d prospect.Data) {
-	tracer := trace.New("mknef")
-	defer tracer.Summarize()
-	filepath.Walk(d.File, func(file string, i os.FileInfo, err error) error {
-		if err != nil || i.IsDir() || !d.Accept(file) {
-			return err
-		}
-		dat := d.Clone()
+func collectData(tracer *trace.Tracer) prospect.RunFunc {
+	return func(b prospect.Builder, d prospect.Data) error {
+		return b.Walk(d.File, func(file string, i os.FileInfo, err error) error {
+			if err != nil || i.IsDir() || !d.Accept(file) {
+				return err
+			}
+			dat := d.Clone()
 
-		tracer.Start(file)
+			tracer.Start(file)
 
-		if dat, err = processData(dat, file); err != nil {
-			tracer.Error(file, err)
-			return nil
-		}
-		ks, err := b.ExecuteCommands(dat)
-		if err != nil {
-			tracer.Error(file, err)
-			return nil
-		}
-		dat.Links = append(dat.Links, ks...)
-		if err := b.Store(dat); err != nil {
-			tracer.Error(file, err)
-			return nil
-		}
+			if dat, err = processData(dat, file); err != nil {
+				return tracer.Error(file, err)
+			}
 
-		d.AcqTime = dat.AcqTime
-		d.ModTime = dat.ModTime
-		// d.Links = append(d.Links, prospect.CreateLinkFrom(dat))
-		extractImages(file, func(base string, f *nef.File) error {
-			n := dat.Clone()
-			n.ClearLinks()
-			buf, err := updateDataFromImage(f, &n)
-			if err != nil {
-				tracer.Error(file, err)
+			d.AcqTime = dat.AcqTime
+			d.ModTime = dat.ModTime
+			err = extractImages(file, func(base string, f *nef.File) error {
+				n := dat.Clone()
+				n.ClearLinks()
+				buf, err := updateDataFromImage(f, &n)
+				if err != nil {
+					return err
+				}
+				n.Links = append(n.Links, prospect.CreateLinkFrom(dat))
+				_, err = b.CreateFile(n, buf)
 				return err
+			})
+			if err != nil {
+				return tracer.Error(file, err)
 			}
-			n.Links = append(n.Links, prospect.CreateLinkFrom(dat))
-			k, err := b.CreateFile(n, buf)
-			if err == nil {
-				k.Role = n.Type
-				dat.Links = append(dat.Links, k)
+			if dat, err = b.StoreData(dat); err != nil {
+				return tracer.Error(file, err)
 			}
-			return err
+			tracer.Done(file, dat)
+			return nil
 		})
-		if err := b.Store(dat); err != nil {
-			tracer.Error(file, err)
-		}
-		tracer.Done(file, dat)
-		return nil
-	})
+	}
 }
 
 func processData(d prospect.Data, file string) (prospect.Data, error) {
@@ -156,18 +149,22 @@ func rawBytes(f *nef.File) ([]byte, error) {
 func extractImages(file string, fn func(string, *nef.File) error) error {
 	var (
 		base = strings.TrimSuffix(filepath.Base(file), filepath.Ext(file))
-		walk func([]*nef.File)
+		walk func([]*nef.File) error
 	)
-	walk = func(files []*nef.File) {
+	walk = func(files []*nef.File) error {
 		for _, f := range files {
-			fn(base, f)
-			walk(f.Files)
+			if err := fn(base, f); err != nil {
+				return err
+			}
+			if err := walk(f.Files); err != nil {
+				return err
+			}
 		}
+		return nil
 	}
 	files, err := nef.DecodeFile(file)
 	if err != nil {
 		return err
 	}
-	walk(files)
-	return nil
+	return walk(files)
 }
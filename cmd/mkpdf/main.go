@@ -1,10 +1,12 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"os"
-	"path/filepath"
+	"os/signal"
+	"syscall"
 
 	"github.com/busoc/prospect"
 	"github.com/busoc/prospect/cmd/internal/trace"
@@ -31,34 +33,37 @@ func main() {
 		}
 		return m.MainType == MainType && m.SubType == SubType
 	}
-	err := prospect.Build(flag.Arg(0), collectData, accept)
+	tracer, err := trace.New("mkpdf")
 	if err != nil {
 		fmt.Fprintln(os.Stderr, err)
 		os.Exit(1)
 	}
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	err = prospect.BuildContext(ctx, flag.Arg(0), collectData(tracer), accept)
+	cancel()
+	tracer.Exit(err)
 }
 
-func collectData(b prospect.Builder, d prospect.Data) {
-	tracer := trace.New("mkpdf")
-	defer tracer.Summarize()
-	filepath.Walk(d.File, func(file string, i os.FileInfo, err error) error {
-		if err != nil || i.IsDir() || !d.Accept(file) {
-			return err
-		}
-		dat := d.Clone()
+func collectData(tracer *trace.Tracer) prospect.RunFunc {
+	return func(b prospect.Builder, d prospect.Data) error {
+		return b.Walk(d.File, func(file string, i os.FileInfo, err error) error {
+			if err != nil || i.IsDir() || !d.Accept(file) {
+				return err
+			}
+			dat := d.Clone()
 
-		tracer.Start(file)
+			tracer.Start(file)
 
-		if dat, err = processData(dat, file); err != nil {
-			tracer.Error(file, err)
+			if dat, err = processData(dat, file); err != nil {
+				return tracer.Error(file, err)
+			}
+			if dat, err = b.StoreData(dat); err != nil {
+				return tracer.Error(file, err)
+			}
+			tracer.Done(file, dat)
 			return nil
-		}
-		if err := b.Store(dat); err != nil {
-			tracer.Error(file, err)
-		}
-		tracer.Done(file, dat)
-		return nil
-	})
+		})
+	}
 }
 
 func processData(d prospect.Data, file string) (prospect.Data, error) {
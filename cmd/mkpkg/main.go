@@ -0,0 +1,403 @@
+package main
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"errors"
+	"flag"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/busoc/prospect"
+)
+
+const (
+	CompressGzip = "gzip"
+	CompressZstd = "zstd"
+
+	ExtTar  = ".tar"
+	ExtGzip = ".gz"
+	ExtZstd = ".zst"
+	ExtSum  = ".sha256"
+	ExtPart = ".part"
+
+	Manifest = "MANIFEST.sha256"
+
+	DirData = "data"
+	DirMeta = "metadata"
+)
+
+type Date struct {
+	time.Time
+}
+
+func (d *Date) Set(str string) error {
+	for _, layout := range []string{"2006-01-02", time.RFC3339} {
+		w, err := time.Parse(layout, str)
+		if err == nil {
+			d.Time = w.UTC()
+			return nil
+		}
+	}
+	return fmt.Errorf("%s: invalid date", str)
+}
+
+func (d *Date) String() string {
+	if d.IsZero() {
+		return "yyyy-mm-dd"
+	}
+	return d.Format(time.RFC3339)
+}
+
+type Size int64
+
+func (s *Size) Set(str string) error {
+	var (
+		mul  int64 = 1
+		unit       = strings.TrimSuffix(strings.ToUpper(str), "B")
+	)
+	switch {
+	case strings.HasSuffix(unit, "K"):
+		mul = 1 << 10
+	case strings.HasSuffix(unit, "M"):
+		mul = 1 << 20
+	case strings.HasSuffix(unit, "G"):
+		mul = 1 << 30
+	case strings.HasSuffix(unit, "T"):
+		mul = 1 << 40
+	}
+	if mul > 1 {
+		unit = unit[:len(unit)-1]
+	}
+	n, err := strconv.ParseInt(unit, 10, 64)
+	if err != nil || n < 0 {
+		return fmt.Errorf("%s: invalid size", str)
+	}
+	*s = Size(n * mul)
+	return nil
+}
+
+func (s *Size) String() string {
+	return strconv.FormatInt(int64(*s), 10)
+}
+
+type Types []string
+
+func (t *Types) Set(str string) error {
+	for _, s := range strings.Split(str, ",") {
+		if s = strings.TrimSpace(s); s != "" {
+			*t = append(*t, strings.ToLower(s))
+		}
+	}
+	return nil
+}
+
+func (t *Types) String() string {
+	return strings.Join(*t, ",")
+}
+
+func (t Types) Accept(str string) bool {
+	if len(t) == 0 {
+		return true
+	}
+	str = strings.ToLower(str)
+	for _, s := range t {
+		if s == str {
+			return true
+		}
+	}
+	return false
+}
+
+func main() {
+	var (
+		dtstart Date
+		dtend   Date
+		limit   Size
+		types   Types
+		dir     = flag.String("d", ".", "output directory")
+		prefix  = flag.String("n", "", "prefix of the packages")
+		minify  = flag.String("z", "", "compress packages (gzip, zstd)")
+	)
+	flag.Var(&dtstart, "f", "acquisition time of first product")
+	flag.Var(&dtend, "t", "acquisition time of last product (excluded)")
+	flag.Var(&limit, "s", "maximum size of a package (eg: 512M, 4G)")
+	flag.Var(&types, "p", "comma separated list of product types")
+	flag.Parse()
+
+	b, err := prospect.Load(flag.Arg(0))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	switch *minify {
+	case "", CompressGzip, CompressZstd:
+	default:
+		fmt.Fprintf(os.Stderr, "%s: unsupported compression\n", *minify)
+		os.Exit(2)
+	}
+	if *prefix == "" {
+		*prefix = "sdc_" + time.Now().UTC().Format("20060102_150405")
+	}
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+
+	p := packer{
+		dir:      *dir,
+		prefix:   *prefix,
+		compress: *minify,
+		limit:    int64(limit),
+	}
+	err = prospect.Walk(ctx, b.MetaDir, func(file string, i os.FileInfo, err error) error {
+		if err != nil || i.IsDir() || filepath.Ext(file) != ".xml" {
+			return err
+		}
+		d, err := prospect.DecodeFile(file)
+		if errors.Is(err, prospect.ErrNotProduct) {
+			log.Printf("warning: skip %s: %s", file, err)
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %w", file, err)
+		}
+		rel, err := filepath.Rel(b.MetaDir, strings.TrimSuffix(file, ".xml"))
+		if err != nil {
+			return err
+		}
+		if !types.Accept(d.Type) {
+			return nil
+		}
+		if !dtstart.IsZero() && d.AcqTime.Before(dtstart.Time) {
+			return nil
+		}
+		if !dtend.IsZero() && !d.AcqTime.Before(dtend.Time) {
+			return nil
+		}
+		if _, err := os.Stat(filepath.Join(b.DataDir, rel)); err != nil {
+			log.Printf("warning: skip %s: %s", file, err)
+			return nil
+		}
+		return p.Add(filepath.Join(b.DataDir, rel), file, rel)
+	})
+	if err != nil {
+		p.Abort()
+	} else {
+		err = p.Close()
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+type packer struct {
+	dir      string
+	prefix   string
+	compress string
+	limit    int64
+	seq      int
+
+	name     string
+	file     *os.File
+	sum      hash.Hash
+	tw       *tar.Writer
+	closer   io.Closer
+	cmd      *exec.Cmd
+	size     int64
+	manifest []string
+}
+
+func (p *packer) Add(data, meta, rel string) error {
+	files := []struct {
+		File string
+		Name string
+	}{
+		{File: data, Name: filepath.ToSlash(filepath.Join(DirData, rel))},
+		{File: meta, Name: filepath.ToSlash(filepath.Join(DirMeta, rel+".xml"))},
+	}
+	var size int64
+	for _, f := range files {
+		i, err := os.Stat(f.File)
+		if err != nil {
+			return err
+		}
+		size += i.Size() + 2*512
+	}
+	if p.tw != nil && p.limit > 0 && p.size+size > p.limit {
+		if err := p.Close(); err != nil {
+			return err
+		}
+	}
+	if p.tw == nil {
+		if err := p.open(); err != nil {
+			return err
+		}
+	}
+	for _, f := range files {
+		if err := p.append(f.File, f.Name); err != nil {
+			return err
+		}
+	}
+	p.size += size
+	return nil
+}
+
+func (p *packer) Close() error {
+	if p.tw == nil {
+		return nil
+	}
+	defer p.reset()
+
+	body := strings.Join(p.manifest, "")
+	h := tar.Header{
+		Name:    Manifest,
+		Size:    int64(len(body)),
+		ModTime: time.Now().UTC(),
+		Mode:    0644,
+	}
+	if err := p.tw.WriteHeader(&h); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(p.tw, body); err != nil {
+		return err
+	}
+	if err := p.tw.Close(); err != nil {
+		return err
+	}
+	if err := p.closer.Close(); err != nil {
+		return err
+	}
+	if p.cmd != nil {
+		if err := p.cmd.Wait(); err != nil {
+			return err
+		}
+	}
+	if err := p.file.Sync(); err != nil {
+		return err
+	}
+	if err := p.file.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(p.file.Name(), p.name); err != nil {
+		return err
+	}
+	sum := fmt.Sprintf("%x  %s\n", p.sum.Sum(nil), filepath.Base(p.name))
+	if err := ioutil.WriteFile(p.name+ExtSum, []byte(sum), 0644); err != nil {
+		return err
+	}
+	log.Printf("%s: %d files (%d bytes)", p.name, len(p.manifest), p.size)
+	return nil
+}
+
+func (p *packer) Abort() {
+	if p.tw == nil {
+		return
+	}
+	p.closer.Close()
+	if p.cmd != nil {
+		p.cmd.Wait()
+	}
+	p.file.Close()
+	os.Remove(p.file.Name())
+	p.reset()
+}
+
+func (p *packer) open() error {
+	p.seq++
+	p.name = filepath.Join(p.dir, fmt.Sprintf("%s_%03d%s", p.prefix, p.seq, ExtTar))
+	switch p.compress {
+	case CompressGzip:
+		p.name += ExtGzip
+	case CompressZstd:
+		p.name += ExtZstd
+	}
+	if err := os.MkdirAll(p.dir, 0755); err != nil {
+		return err
+	}
+	f, err := os.Create(p.name + ExtPart)
+	if err != nil {
+		return err
+	}
+	p.file = f
+	p.sum = sha256.New()
+
+	w := io.MultiWriter(f, p.sum)
+	switch p.compress {
+	case CompressGzip:
+		z := gzip.NewWriter(w)
+		p.tw, p.closer = tar.NewWriter(z), z
+	case CompressZstd:
+		p.cmd = exec.Command("zstd", "-q", "-c")
+		p.cmd.Stdout = w
+		p.cmd.Stderr = os.Stderr
+		in, err := p.cmd.StdinPipe()
+		if err == nil {
+			err = p.cmd.Start()
+		}
+		if err != nil {
+			f.Close()
+			os.Remove(f.Name())
+			p.cmd = nil
+			return err
+		}
+		p.tw, p.closer = tar.NewWriter(in), in
+	default:
+		p.tw, p.closer = tar.NewWriter(w), nopCloser{}
+	}
+	return nil
+}
+
+func (p *packer) append(file, name string) error {
+	r, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	i, err := r.Stat()
+	if err != nil {
+		return err
+	}
+	h, err := tar.FileInfoHeader(i, "")
+	if err != nil {
+		return err
+	}
+	h.Name = name
+	if err := p.tw.WriteHeader(h); err != nil {
+		return err
+	}
+	sum := sha256.New()
+	if _, err := io.Copy(io.MultiWriter(p.tw, sum), r); err != nil {
+		return err
+	}
+	p.manifest = append(p.manifest, fmt.Sprintf("%x  %s\n", sum.Sum(nil), name))
+	return nil
+}
+
+func (p *packer) reset() {
+	p.file = nil
+	p.sum = nil
+	p.tw = nil
+	p.closer = nil
+	p.cmd = nil
+	p.size = 0
+	p.manifest = nil
+}
+
+type nopCloser struct{}
+
+func (nopCloser) Close() error { return nil }
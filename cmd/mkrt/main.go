@@ -2,14 +2,18 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"encoding/binary"
 	"errors"
 	"flag"
 	"fmt"
 	"io"
 	"os"
-	"path/filepath"
+	"os/signal"
+	"sort"
+	"strconv"
 	"strings"
+	"syscall"
 	"time"
 
 	"github.com/busoc/prospect"
@@ -26,60 +30,88 @@ const (
 	vmu = "vmu"
 )
 
+type options struct {
+	Gap       time.Duration
+	Index     bool
+	SplitId   bool
+	SplitTime time.Duration
+}
+
 func main() {
+	var opts options
+	flag.DurationVar(&opts.Gap, "g", 0, "minimum interval of time between two records to report a gap")
+	flag.BoolVar(&opts.Index, "x", false, "create a csv product with the index of the records")
+	flag.BoolVar(&opts.SplitId, "split-id", false, "split rt files by pid, code or channel")
+	flag.DurationVar(&opts.SplitTime, "split-time", 0, "split rt files by time window")
 	flag.Parse()
 
 	accept := func(d prospect.Data) bool {
-		switch strings.ToLower(d.Type) {
-		default:
-		case strings.ToLower(prospect.TypePTH), strings.ToLower(prospect.TypePDH), strings.ToLower(prospect.TypeHRD):
-			return true
-		}
-		mt, err := mime.Parse(d.Mime)
-		if err != nil {
-			return false
-		}
-		switch strings.ToLower(mt.Params["type"]) {
-		case pth, pdh, hrd, vmu:
-		default:
-			return false
-		}
-		return true
+		return kindOf(d) != ""
 	}
-	err := prospect.Build(flag.Arg(0), collectData, accept)
+	tracer, err := trace.New("mkrt")
 	if err != nil {
 		fmt.Fprintln(os.Stderr, err)
 		os.Exit(1)
 	}
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	err = prospect.BuildContext(ctx, flag.Arg(0), collectData(tracer, opts), accept)
+	cancel()
+	tracer.Exit(err)
 }
 
-func collectData(b prospect.Builder, d prospect.Data) {
-	var (
-		buffer = make([]byte, 8<<20)
-		tracer = trace.New("mkrt")
-	)
-	filepath.Walk(d.File, func(file string, i os.FileInfo, err error) error {
-		if err != nil || i.IsDir() || !d.Accept(file) {
-			return err
-		}
-		dat := d.Clone()
+func collectData(tracer *trace.Tracer, opts options) prospect.RunFunc {
+	return func(b prospect.Builder, d prospect.Data) error {
+		buffer := make([]byte, 8<<20)
+		return b.Walk(d.File, func(file string, i os.FileInfo, err error) error {
+			if err != nil || i.IsDir() || !d.Accept(file) {
+				return err
+			}
+			dat := d.Clone()
 
-		tracer.Start(file)
-		defer tracer.Done(file, dat)
+			tracer.Start(file)
 
-		dat, err = processData(dat, file, buffer)
-		if err != nil {
-			tracer.Error(file, err)
+			var (
+				index *indexer
+				split *splitter
+				rs    []recorder
+			)
+			if opts.Index {
+				index = newIndexer(kindOf(dat), dat.Parameters)
+				rs = append(rs, index)
+			}
+			if opts.SplitId || opts.SplitTime > 0 {
+				if split, err = newSplitter(opts.SplitId, opts.SplitTime, dat.Parameters); err != nil {
+					return tracer.Error(file, err)
+				}
+				defer split.Close()
+				rs = append(rs, split)
+			}
+			dat, err = processData(dat, file, buffer, opts.Gap, rs...)
+			if err != nil {
+				return tracer.Error(file, err)
+			}
+			if dat, err = b.StoreData(dat); err != nil {
+				return tracer.Error(file, err)
+			}
+			if index != nil {
+				if err := index.Store(b, dat); err != nil {
+					return tracer.Error(file, err)
+				}
+			}
+			if split != nil {
+				if err := split.Store(b, dat); err != nil {
+					return tracer.Error(file, err)
+				}
+			}
+			tracer.Done(file, dat)
 			return nil
-		}
-		if err := b.Store(dat); err != nil {
-			tracer.Error(file, err)
-		}
-		return nil
-	})
+		})
+	}
 }
 
-func processData(d prospect.Data, file string, buffer []byte) (prospect.Data, error) {
+// processData reads the records of file to update d. Each record is also given
+// to the recorders.
+func processData(d prospect.Data, file string, buffer []byte, gap time.Duration, rs ...recorder) (prospect.Data, error) {
 	if err := prospect.ReadFile(&d, file); err != nil {
 		return d, err
 	}
@@ -90,50 +122,231 @@ func processData(d prospect.Data, file string, buffer []byte) (prospect.Data, er
 	defer r.Close()
 
 	var (
-		count   int
-		rs      = rt.NewReader(r)
-		getTime = getTimeFunc(d.Type)
+		rr     = rt.NewReader(r)
+		kind   = kindOf(d)
+		decode = decodeFunc(kind)
+		st     = newStats(gap, seqMask(kind))
+		offset int
 	)
-
-	for i := 0; ; i++ {
-		if _, err := rs.Read(buffer); err != nil {
+	for {
+		n, err := rr.Read(buffer)
+		if err != nil {
 			if !errors.Is(err, io.EOF) {
 				d.Register(prospect.FileInvalid, true)
 			}
 			break
 		}
-		if i == 0 {
-			d.AcqTime = getTime(buffer)
+		i := decode(buffer[:n])
+		for _, r := range rs {
+			if err := r.Record(i, offset, buffer[:n]); err != nil {
+				return d, err
+			}
+		}
+		offset += n
+		if st.Count == 0 {
+			d.AcqTime = i.When
 		}
-		d.ModTime = getTime(buffer)
-		count++
+		d.ModTime = i.When
+		st.Update(i)
 	}
 	delta := d.ModTime.Sub(d.AcqTime)
 	d.Register(prospect.FileDuration, delta)
-	d.Register(prospect.FileRecord, count)
+	d.Register(prospect.FileRecord, st.Count)
+	st.Register(&d)
 	return d, nil
 }
 
+const (
+	rtGaps       = "rt.gaps"
+	rtGapsTime   = "rt.gaps.duration"
+	rtGapsMax    = "rt.gaps.max"
+	rtUnordered  = "rt.unordered"
+	rtDuplicates = "rt.duplicates"
+	rtSeqErrors  = "rt.sequence.errors"
+	rtSeqMissing = "rt.sequence.missing"
+	rtCount      = "rt.%s.%s.count"
+	rtList       = "rt.%s.list"
+)
+
+// kindOf gives the kind of records of a rt file from the type parameter of
+// its mime type or else from its type. An empty string is returned for files
+// that are not rt files.
+func kindOf(d prospect.Data) string {
+	if mt, err := mime.Parse(d.Mime); err == nil {
+		switch k := strings.ToLower(mt.Params["type"]); k {
+		case pth, pdh, hrd, vmu:
+			return k
+		}
+	}
+	switch strings.ToLower(d.Type) {
+	case strings.ToLower(prospect.TypePTH):
+		return pth
+	case strings.ToLower(prospect.TypePDH):
+		return pdh
+	case strings.ToLower(prospect.TypeHRD):
+		return hrd
+	}
+	return ""
+}
+
+func seqMask(kind string) int64 {
+	if kind == vmu {
+		return 0xFFFFFFFF
+	}
+	return 0x3FFF
+}
+
+type ident struct {
+	Kind  string
+	Value string
+}
+
+// info describes a record. The first identifier of Ids identifies the source
+// of the record for the sequence and duplicate checks.
 type info struct {
-	Id   int
-	Seq  int
+	Ids  []ident
+	Seq  int64
 	When time.Time
 }
 
-func getTimeFunc(str string) func([]byte) time.Time {
-	switch strings.ToLower(str) {
+func (i info) key() string {
+	var id string
+	if len(i.Ids) > 0 {
+		id = i.Ids[0].Value
+	}
+	return fmt.Sprintf("%s/%d/%d", id, i.Seq, i.When.UnixNano())
+}
+
+type stats struct {
+	Count      int
+	Gaps       int
+	GapsTime   time.Duration
+	GapsMax    time.Duration
+	Unordered  int
+	Duplicates int
+	SeqErrors  int
+	SeqMissing int
+
+	threshold time.Duration
+	mask      int64
+	last      time.Time
+	kinds     []string
+	counts    map[ident]int
+	seqs      map[string]int64
+	seen      map[string]struct{}
+}
+
+func newStats(gap time.Duration, mask int64) *stats {
+	return &stats{
+		threshold: gap,
+		mask:      mask,
+		counts:    make(map[ident]int),
+		seqs:      make(map[string]int64),
+		seen:      make(map[string]struct{}),
+	}
+}
+
+func (s *stats) Update(i info) {
+	if s.Count > 0 {
+		delta := i.When.Sub(s.last)
+		if delta < 0 {
+			s.Unordered++
+		}
+		if s.threshold > 0 && delta > s.threshold {
+			s.Gaps++
+			s.GapsTime += delta
+			if delta > s.GapsMax {
+				s.GapsMax = delta
+			}
+		}
+	}
+	if !i.When.Before(s.last) {
+		s.last = i.When
+	}
+	s.Count++
+
+	if len(i.Ids) == 0 {
+		return
+	}
+	if len(s.kinds) == 0 {
+		for _, id := range i.Ids {
+			s.kinds = append(s.kinds, id.Kind)
+		}
+	}
+	for _, id := range i.Ids {
+		s.counts[id]++
+	}
+
+	k := i.key()
+	if _, ok := s.seen[k]; ok {
+		s.Duplicates++
+		return
+	}
+	s.seen[k] = struct{}{}
+
+	if i.Seq < 0 {
+		return
+	}
+	id := i.Ids[0].Value
+	if prev, ok := s.seqs[id]; ok {
+		if next := (prev + 1) & s.mask; i.Seq != next {
+			s.SeqErrors++
+			// a counter going back (repeated or late packets) does not count
+			// as missing packets
+			if diff := (i.Seq - next) & s.mask; diff <= s.mask/2 {
+				s.SeqMissing += int(diff)
+			}
+		}
+	}
+	s.seqs[id] = i.Seq
+}
+
+func (s *stats) Register(d *prospect.Data) {
+	d.Register(rtUnordered, s.Unordered)
+	if s.threshold > 0 {
+		d.Register(rtGaps, s.Gaps)
+		d.Register(rtGapsTime, s.GapsTime)
+		d.Register(rtGapsMax, s.GapsMax)
+	}
+	if len(s.kinds) == 0 {
+		return
+	}
+	d.Register(rtDuplicates, s.Duplicates)
+	if len(s.seqs) > 0 {
+		d.Register(rtSeqErrors, s.SeqErrors)
+		d.Register(rtSeqMissing, s.SeqMissing)
+	}
+	for _, k := range s.kinds {
+		var vs []string
+		for id := range s.counts {
+			if id.Kind == k {
+				vs = append(vs, id.Value)
+			}
+		}
+		sort.Strings(vs)
+		d.Register(fmt.Sprintf(rtList, k), strings.Join(vs, ","))
+		for _, v := range vs {
+			d.Register(fmt.Sprintf(rtCount, k, v), s.counts[ident{Kind: k, Value: v}])
+		}
+	}
+}
+
+func decodeFunc(kind string) func([]byte) info {
+	switch kind {
 	default:
-		return func(_ []byte) time.Time { return time.Now() }
-	case strings.ToLower(prospect.TypePTH):
-		return getTimeTM
-	case strings.ToLower(prospect.TypePDH):
-		return getTimePP
-	case strings.ToLower(prospect.TypeHRD):
-		return getTimeHRD
+		return func(_ []byte) info { return info{When: time.Now()} }
+	case pth:
+		return decodeTM
+	case pdh:
+		return decodePP
+	case hrd:
+		return decodeHRD
+	case vmu:
+		return decodeVMU
 	}
 }
 
-func getTimePP(buf []byte) time.Time {
+func decodePP(buf []byte) info {
 	c := struct {
 		Size   uint32
 		State  uint8
@@ -146,12 +359,19 @@ func getTimePP(buf []byte) time.Time {
 		Len    uint16
 	}{}
 	if err := binary.Read(bytes.NewReader(buf), binary.BigEndian, &c); err != nil {
-		return time.Time{}
+		return info{}
+	}
+	return info{
+		Ids: []ident{
+			{Kind: "code", Value: fmt.Sprintf("%x", c.Code)},
+			{Kind: "unit", Value: strconv.Itoa(int(c.Unit))},
+		},
+		Seq:  -1,
+		When: timutil.Join5(c.Coarse, c.Fine),
 	}
-	return timutil.Join5(c.Coarse, c.Fine)
 }
 
-func getTimeTM(buf []byte) time.Time {
+func decodeTM(buf []byte) info {
 	c := struct {
 		Size      uint32
 		RecCoarse uint32
@@ -166,12 +386,19 @@ func getTimeTM(buf []byte) time.Time {
 		Info      uint8
 	}{}
 	if err := binary.Read(bytes.NewReader(buf), binary.BigEndian, &c); err != nil {
-		return time.Time{}
+		return info{}
+	}
+	return info{
+		Ids: []ident{
+			{Kind: "pid", Value: strconv.Itoa(int(c.Pid))},
+			{Kind: "sid", Value: strconv.Itoa(int(c.Sid))},
+		},
+		Seq:  int64(c.Seq) & seqMask(pth),
+		When: timutil.Join5(c.AcqCoarse, c.AcqFine),
 	}
-	return timutil.Join5(c.AcqCoarse, c.AcqFine)
 }
 
-func getTimeHRD(buf []byte) time.Time {
+func decodeHRD(buf []byte) info {
 	c := struct {
 		Size      uint32
 		Err       uint16
@@ -183,7 +410,61 @@ func getTimeHRD(buf []byte) time.Time {
 		AcqFine   uint8
 	}{}
 	if err := binary.Read(bytes.NewReader(buf), binary.BigEndian, &c); err != nil {
-		return time.Time{}
+		return info{}
+	}
+	return info{
+		Ids: []ident{
+			{Kind: "channel", Value: strconv.Itoa(int(c.Channel))},
+			{Kind: "payload", Value: strconv.Itoa(int(c.Payload))},
+		},
+		Seq:  -1,
+		When: timutil.Join5(c.AcqCoarse, c.AcqFine),
+	}
+}
+
+const hrdlSync = 0xf82e3553
+
+// decodeVMU decodes the VMU header of the HRDL packet that follows the header
+// of a HRD record. Records without the HRDL sync word only get the acquisition
+// time of their HRD header.
+func decodeVMU(buf []byte) info {
+	c := struct {
+		Size      uint32
+		Err       uint16
+		Payload   uint8
+		Channel   uint8
+		RecCoarse uint32
+		RecFine   uint8
+		AcqCoarse uint32
+		AcqFine   uint8
+		Sync      uint32
+	}{}
+	r := bytes.NewReader(buf)
+	if err := binary.Read(r, binary.BigEndian, &c); err != nil {
+		return info{}
+	}
+	if c.Sync != hrdlSync {
+		return info{Seq: -1, When: timutil.Join5(c.AcqCoarse, c.AcqFine)}
+	}
+	v := struct {
+		Len      uint32
+		Channel  uint8
+		Origin   uint8
+		_        uint16
+		Sequence uint32
+		Coarse   uint32
+		Fine     uint16
+		_        uint16
+	}{}
+	if err := binary.Read(r, binary.LittleEndian, &v); err != nil {
+		return info{Seq: -1, When: timutil.Join5(c.AcqCoarse, c.AcqFine)}
+	}
+	return info{
+		Ids: []ident{
+			{Kind: "channel", Value: strconv.Itoa(int(v.Channel))},
+			{Kind: "origin", Value: fmt.Sprintf("%02x", v.Origin)},
+		},
+		Seq:  int64(v.Sequence),
+		When: timutil.Join6(v.Coarse, v.Fine),
 	}
-	return timutil.Join5(c.AcqCoarse, c.AcqFine)
 }
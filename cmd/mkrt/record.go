@@ -0,0 +1,222 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/busoc/prospect"
+)
+
+const (
+	typeIndex = "packet index"
+
+	rtWindow = "rt.window"
+)
+
+// recorder receives each record read from a rt file with its offset in the
+// file.
+type recorder interface {
+	Record(i info, offset int, buf []byte) error
+}
+
+type indexer struct {
+	buf bytes.Buffer
+	ws  *csv.Writer
+
+	params []prospect.Parameter
+}
+
+// newIndexer creates an indexer whose product has the given parameters (the
+// parameters of the file section).
+func newIndexer(kind string, params []prospect.Parameter) *indexer {
+	x := indexer{
+		params: append([]prospect.Parameter{}, params...),
+	}
+	x.ws = csv.NewWriter(&x.buf)
+	x.ws.Write(indexHeaders(kind))
+	return &x
+}
+
+func (x *indexer) Record(i info, offset int, buf []byte) error {
+	row := []string{strconv.Itoa(offset), i.When.UTC().Format(time.RFC3339Nano)}
+	for _, id := range i.Ids {
+		row = append(row, id.Value)
+	}
+	return x.ws.Write(append(row, strconv.Itoa(len(buf))))
+}
+
+func (x *indexer) Store(b prospect.Builder, d prospect.Data) error {
+	x.ws.Flush()
+	if err := x.ws.Error(); err != nil {
+		return err
+	}
+	buf := x.buf.Bytes()
+
+	n := d.Clone()
+	n.ClearLinks()
+	n.File = d.File + ".csv"
+	n.Type = typeIndex
+	n.Mime = prospect.MimeCsv
+	n.Level = 1
+	n.Parameters = append([]prospect.Parameter{}, x.params...)
+	if err := prospect.ReadFrom(&n, bytes.NewReader(buf)); err != nil {
+		return err
+	}
+	n.Links = append(n.Links, prospect.CreateLinkFrom(d))
+	_, err := b.CreateFile(n, buf)
+	return err
+}
+
+func indexHeaders(kind string) []string {
+	hs := []string{"offset", "time"}
+	switch kind {
+	case pth:
+		hs = append(hs, "pid", "sid")
+	case pdh:
+		hs = append(hs, "code", "unit")
+	case hrd:
+		hs = append(hs, "channel", "payload")
+	case vmu:
+		hs = append(hs, "channel", "origin")
+	}
+	return append(hs, "length")
+}
+
+// splitter writes the records of a rt file into slices by identifier (the
+// first identifier of the records) and/or by time window.
+type splitter struct {
+	byId   bool
+	window time.Duration
+
+	dir    string
+	slices map[string]*slice
+	keys   []string
+	params []prospect.Parameter
+}
+
+type slice struct {
+	file   *os.File
+	writer *bufio.Writer
+
+	id     ident
+	starts time.Time
+	first  time.Time
+	last   time.Time
+	count  int
+}
+
+// newSplitter creates a splitter whose slices have the given parameters (the
+// parameters of the file section).
+func newSplitter(byId bool, window time.Duration, params []prospect.Parameter) (*splitter, error) {
+	dir, err := ioutil.TempDir("", "mkrt-")
+	if err != nil {
+		return nil, err
+	}
+	s := splitter{
+		byId:   byId,
+		window: window,
+		dir:    dir,
+		slices: make(map[string]*slice),
+		params: append([]prospect.Parameter{}, params...),
+	}
+	return &s, nil
+}
+
+func (s *splitter) Record(i info, _ int, buf []byte) error {
+	var (
+		id     ident
+		starts time.Time
+	)
+	if s.byId && len(i.Ids) > 0 {
+		id = i.Ids[0]
+	}
+	if s.window > 0 {
+		starts = i.When.Truncate(s.window)
+	}
+	key := fmt.Sprintf("%s/%d", id.Value, starts.UnixNano())
+	c, ok := s.slices[key]
+	if !ok {
+		f, err := os.Create(filepath.Join(s.dir, strconv.Itoa(len(s.keys))))
+		if err != nil {
+			return err
+		}
+		c = &slice{
+			file:   f,
+			writer: bufio.NewWriter(f),
+			id:     id,
+			starts: starts,
+			first:  i.When,
+		}
+		s.slices[key] = c
+		s.keys = append(s.keys, key)
+	}
+	if i.When.Before(c.first) {
+		c.first = i.When
+	}
+	if i.When.After(c.last) {
+		c.last = i.When
+	}
+	c.count++
+	_, err := c.writer.Write(buf)
+	return err
+}
+
+// Store creates a product for each slice linked to d, the product of the rt
+// file.
+func (s *splitter) Store(b prospect.Builder, d prospect.Data) error {
+	var (
+		base = strings.TrimSuffix(filepath.Base(d.File), prospect.ExtGZ)
+		ext  = filepath.Ext(base)
+		stem = strings.TrimSuffix(base, ext)
+	)
+	for _, k := range s.keys {
+		c := s.slices[k]
+		if err := c.writer.Flush(); err != nil {
+			return err
+		}
+		if _, err := c.file.Seek(0, 0); err != nil {
+			return err
+		}
+		n := d.Clone()
+		n.ClearLinks()
+		n.Parameters = append([]prospect.Parameter{}, s.params...)
+		if err := prospect.ReadFrom(&n, c.file); err != nil {
+			return err
+		}
+		name := stem
+		if c.id.Value != "" {
+			name += "_" + c.id.Kind + c.id.Value
+			n.Register("rt."+c.id.Kind, c.id.Value)
+		}
+		if s.window > 0 {
+			name += "_" + c.starts.UTC().Format("20060102_150405")
+			n.Register(rtWindow, s.window)
+		}
+		n.File = filepath.Join(filepath.Dir(d.File), name+ext)
+		n.Level = 1
+		n.AcqTime = c.first
+		n.ModTime = c.last
+		n.Register(prospect.FileDuration, c.last.Sub(c.first))
+		n.Register(prospect.FileRecord, c.count)
+		n.Links = append(n.Links, prospect.CreateLinkFrom(d))
+		if _, err := b.CopyFile(n, c.file.Name()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *splitter) Close() error {
+	for _, c := range s.slices {
+		c.file.Close()
+	}
+	return os.RemoveAll(s.dir)
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/busoc/prospect"
+)
+
+func main() {
+	inventory := flag.String("i", "", "inventory file to update")
+	flag.Parse()
+
+	b, err := prospect.Load(flag.Arg(0))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	if flag.NArg() != 3 {
+		fmt.Fprintln(os.Stderr, "usage: mksup [-i inventory] <config> <product> <file>")
+		os.Exit(2)
+	}
+	path, err := b.Supersede(flag.Arg(1), flag.Arg(2))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	fmt.Printf("%s superseded by %s\n", flag.Arg(1), path)
+	if *inventory == "" {
+		return
+	}
+	iv, err := prospect.ReadInventory(context.Background(), b.MetaDir, nil)
+	if err == nil {
+		err = iv.WriteFile(*inventory)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
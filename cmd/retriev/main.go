@@ -5,6 +5,7 @@ import (
 	"bufio"
 	"bytes"
 	"compress/gzip"
+	"context"
 	"encoding/json"
 	"errors"
 	"flag"
@@ -15,8 +16,10 @@ import (
 	"net/http"
 	"net/url"
 	"os"
+	"os/signal"
 	"path/filepath"
 	"strings"
+	"syscall"
 	"time"
 
 	"github.com/midbel/try"
@@ -58,21 +61,25 @@ func main() {
 	if *secure {
 		u.Scheme = "https"
 	}
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+
 	var err error
 	switch strings.ToLower(*archive) {
 	case "", "parameters":
 		u.Path = fmt.Sprintf(APIP, *instance)
-		err = retrParameters(u, *minify, *flat, dtstart.Time.UTC(), dtend.Time.UTC(), flag.Arg(0), flag.Arg(1))
+		err = retrParameters(ctx, u, *minify, *flat, dtstart.Time.UTC(), dtend.Time.UTC(), flag.Arg(0), flag.Arg(1))
 	case "events":
 		u.Path = fmt.Sprintf(APIE, *instance)
-		err = retrEvents(u, *minify, *flat, dtstart.Time.UTC(), dtend.Time.UTC(), flag.Arg(0))
+		err = retrEvents(ctx, u, *minify, *flat, dtstart.Time.UTC(), dtend.Time.UTC(), flag.Arg(0))
 	case "commands":
 		u.Path = fmt.Sprintf(APIC, *instance)
-		err = retrCommands(u, *minify, *flat, dtstart.Time.UTC(), dtend.Time.UTC(), flag.Arg(0))
+		err = retrCommands(ctx, u, *minify, *flat, dtstart.Time.UTC(), dtend.Time.UTC(), flag.Arg(0))
 	default:
 		err = fmt.Errorf("%s: unknown archive type", *archive)
 		os.Exit(2)
 	}
+	cancel()
 	if err != nil {
 		fmt.Fprintln(os.Stderr, err)
 		os.Exit(2)
@@ -164,8 +171,8 @@ func (r Request) Ext() string {
 	return e
 }
 
-func (r Request) Copy(ws io.Writer, starts, ends time.Time) (int64, error) {
-	req, err := r.Make(starts, ends)
+func (r Request) Copy(ctx context.Context, ws io.Writer, starts, ends time.Time) (int64, error) {
+	req, err := r.Make(ctx, starts, ends)
 	if err != nil {
 		return 0, err
 	}
@@ -184,7 +191,7 @@ func (r Request) Copy(ws io.Writer, starts, ends time.Time) (int64, error) {
 	return io.Copy(ws, resp.Body)
 }
 
-func (r Request) Make(starts, ends time.Time) (*http.Request, error) {
+func (r Request) Make(ctx context.Context, starts, ends time.Time) (*http.Request, error) {
 	vs := url.Values{}
 	vs.Set("start", starts.Format(time.RFC3339))
 	vs.Set("stop", ends.Format(time.RFC3339))
@@ -195,7 +202,7 @@ func (r Request) Make(starts, ends time.Time) (*http.Request, error) {
 		method = http.MethodPost
 	}
 
-	req, err := http.NewRequest(method, r.api.String(), bytes.NewReader(r.body))
+	req, err := http.NewRequestWithContext(ctx, method, r.api.String(), bytes.NewReader(r.body))
 	if err == nil {
 		req.Header.Set("accept-encoding", "identity")
 		if r.isText() {
@@ -213,9 +220,12 @@ func (r Request) String() string {
 	return r.api.String()
 }
 
-func retrParameters(api url.URL, mini, flat bool, dtstart, dtend time.Time, base, dir string) error {
+func retrParameters(ctx context.Context, api url.URL, mini, flat bool, dtstart, dtend time.Time, base, dir string) error {
 	base = filepath.Clean(base)
 	return filepath.Walk(base, func(file string, i os.FileInfo, err error) error {
+		if err == nil {
+			err = ctx.Err()
+		}
 		if err != nil || i.IsDir() {
 			return err
 		}
@@ -229,38 +239,49 @@ func retrParameters(api url.URL, mini, flat bool, dtstart, dtend time.Time, base
 		dst, err := mkdir(base, dir, file)
 		if err == nil {
 			req := CsvRequest(api, body, mini)
-			err = fetchData(dst, flat, req, dtstart, dtend)
+			err = fetchData(ctx, dst, flat, req, dtstart, dtend)
 		}
 		return err
 	})
 }
 
-func retrCommands(api url.URL, mini, flat bool, dtstart, dtend time.Time, dir string) error {
-	return fetchData(dir, flat, JsonRequest(api, nil, mini), dtstart, dtend)
+func retrCommands(ctx context.Context, api url.URL, mini, flat bool, dtstart, dtend time.Time, dir string) error {
+	return fetchData(ctx, dir, flat, JsonRequest(api, nil, mini), dtstart, dtend)
 }
 
-func retrEvents(api url.URL, mini, flat bool, dtstart, dtend time.Time, dir string) error {
-	return fetchData(dir, flat, TextRequest(api, nil, mini), dtstart, dtend)
+func retrEvents(ctx context.Context, api url.URL, mini, flat bool, dtstart, dtend time.Time, dir string) error {
+	return fetchData(ctx, dir, flat, TextRequest(api, nil, mini), dtstart, dtend)
 }
 
-func fetchData(dir string, flat bool, req Request, starts, ends time.Time) error {
+func fetchData(ctx context.Context, dir string, flat bool, req Request, starts, ends time.Time) error {
 	if ends.Before(starts) {
 		return fmt.Errorf("invalid interval")
 	}
-	return timeRange(starts, ends, Day, func(when time.Time) error {
+	return timeRange(ctx, starts, ends, Day, func(when time.Time) error {
 		var (
 			year = fmt.Sprintf("%04d", when.Year())
 			doy  = fmt.Sprintf("%03d", when.YearDay())
 			file = filepath.Join(dir, year, doy)
 		)
 		if flat {
-			return createFile(file+req.Ext(), req, when, when.Add(Day))
+			file += req.Ext()
+		} else {
+			file += ".tar"
+		}
+		// a file written before the end of its day can be incomplete and is
+		// retrieved again
+		if i, err := os.Stat(file); err == nil && i.ModTime().After(when.Add(Day)) {
+			log.Printf("skip %s: already retrieved", file)
+			return nil
+		}
+		if flat {
+			return createFile(ctx, file, req, when, when.Add(Day))
 		}
-		return createArchive(file+".tar", req, when)
+		return createArchive(ctx, file, req, when)
 	})
 }
 
-func createFile(file string, req Request, starts, ends time.Time) error {
+func createFile(ctx context.Context, file string, req Request, starts, ends time.Time) error {
 	if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
 		return err
 	}
@@ -268,25 +289,32 @@ func createFile(file string, req Request, starts, ends time.Time) error {
 	defer log.Printf("end writting %s", file)
 
 	return try.Try(MaxAttempt, func(i int) error {
-		w, err := os.Create(file)
+		w, err := createTemp(file)
 		if err != nil {
 			return err
 		}
-		defer w.Close()
+		defer func() {
+			w.Close()
+			os.Remove(w.Name())
+		}()
 
-		_, err = req.Copy(w, starts, ends)
+		_, err = req.Copy(ctx, w, starts, ends)
 		if err != nil {
 			log.Printf("%s: attempt #%d failed: %v", req, i, err)
+			return abortOnCancel(ctx, err)
 		}
-		return err
+		if err := w.Close(); err != nil {
+			return err
+		}
+		return os.Rename(w.Name(), file)
 	})
 }
 
-func createArchive(file string, req Request, when time.Time) error {
+func createArchive(ctx context.Context, file string, req Request, when time.Time) error {
 	if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
 		return err
 	}
-	w, err := os.Create(file)
+	w, err := createTemp(file)
 	if err != nil {
 		return err
 	}
@@ -298,15 +326,11 @@ func createArchive(file string, req Request, when time.Time) error {
 		tw      = tar.NewWriter(w)
 	)
 	defer func() {
-		tw.Close()
 		w.Close()
-
-		if written == 0 {
-			os.Remove(file)
-		}
+		os.Remove(w.Name())
 	}()
 
-	return timeRange(when, when.Add(Day), time.Hour, func(when time.Time) error {
+	err = timeRange(ctx, when, when.Add(Day), time.Hour, func(when time.Time) error {
 		return try.Try(MaxAttempt, func(i int) error {
 			rw, err := ioutil.TempFile("", "data*.csv.gz")
 			if err != nil {
@@ -317,11 +341,11 @@ func createArchive(file string, req Request, when time.Time) error {
 				os.Remove(rw.Name())
 			}()
 
-			if size, err := req.Copy(rw, when, when.Add(time.Hour)); err != nil || size == 0 {
+			if size, err := req.Copy(ctx, rw, when, when.Add(time.Hour)); err != nil || size == 0 {
 				if err != nil {
 					log.Printf("%s: attempt #%d failed: %v", req, i, err)
 				}
-				return err
+				return abortOnCancel(ctx, err)
 			}
 			written++
 			if err := appendFile(tw, rw, when); err != nil {
@@ -330,6 +354,31 @@ func createArchive(file string, req Request, when time.Time) error {
 			return nil
 		})
 	})
+	if err != nil || written == 0 {
+		return err
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return os.Rename(w.Name(), file)
+}
+
+func createTemp(file string) (*os.File, error) {
+	w, err := ioutil.TempFile(filepath.Dir(file), "."+filepath.Base(file)+".*")
+	if err == nil {
+		err = w.Chmod(0644)
+	}
+	return w, err
+}
+
+func abortOnCancel(ctx context.Context, err error) error {
+	if err == nil || ctx.Err() == nil {
+		return err
+	}
+	return fmt.Errorf("%w: %s", try.ErrAbort, ctx.Err())
 }
 
 func appendFile(tw *tar.Writer, rw *os.File, when time.Time) error {
@@ -355,12 +404,15 @@ func appendFile(tw *tar.Writer, rw *os.File, when time.Time) error {
 	return err
 }
 
-func timeRange(starts, ends time.Time, step time.Duration, fn TimeFunc) error {
+func timeRange(ctx context.Context, starts, ends time.Time, step time.Duration, fn TimeFunc) error {
 	if starts.After(ends) {
 		return fmt.Errorf("invalid interval: %s - %s", starts, ends)
 	}
 	var err error
 	for starts.Before(ends) {
+		if err = ctx.Err(); err != nil {
+			break
+		}
 		if err = fn(starts); err != nil && !errors.Is(err, io.EOF) {
 			break
 		}
@@ -3,6 +3,7 @@ package main
 import (
 	"bytes"
 	"compress/gzip"
+	"context"
 	"crypto/md5"
 	"fmt"
 	"io"
@@ -17,7 +18,10 @@ import (
 	"golang.org/x/crypto/ssh"
 )
 
-const DefaultBufferSize = 1 << 15
+const (
+	DefaultBufferSize = 1 << 15
+	ExtPart           = ".part"
+)
 
 type Directory struct {
 	Local     string
@@ -75,7 +79,7 @@ type Client struct {
 	conn *Reporter
 }
 
-func (c *Client) Copy(d Directory, buffer int64) error {
+func (c *Client) Copy(ctx context.Context, d Directory, buffer int64) error {
 	if buffer <= 0 {
 		buffer = DefaultBufferSize
 	}
@@ -88,16 +92,20 @@ func (c *Client) Copy(d Directory, buffer int64) error {
 		remote = local
 	}
 	err := filepath.Walk(d.Local, func(file string, i os.FileInfo, err error) error {
-		if err != nil || i.IsDir() {
+		if err == nil {
+			err = ctx.Err()
+		}
+		if err != nil || i.IsDir() || filepath.Ext(file) == ExtPart {
 			return err
 		}
 		r, err := os.Open(file)
 		if err != nil {
 			return err
 		}
+		var done bool
 		defer func(file string) {
 			r.Close()
-			if !d.Keep {
+			if done && !d.Keep {
 				os.Remove(file)
 			}
 		}(file)
@@ -116,10 +124,12 @@ func (c *Client) Copy(d Directory, buffer int64) error {
 				}
 			}
 		}
-		n, err := c.copy(r, i, rfile, d.Compress, d.Integrity, buf)
+		n, err := c.copy(reader{Reader: r, ctx: ctx}, i, rfile, d.Compress, d.Integrity, buf)
 		if err != nil {
 			log.Printf("error transfer file: %s -> %s: %s", file, rfile, err)
+			return ctx.Err()
 		}
+		done = true
 		log.Printf("end transfer file: %s -> %s (%d bytes)", file, rfile, n)
 		return nil
 	})
@@ -133,20 +143,22 @@ func (c *Client) copy(r io.Reader, i os.FileInfo, file string, minify, integrity
 	if err := c.client.MkdirAll(filepath.Dir(file)); err != nil {
 		return 0, err
 	}
-	var w io.Writer
-	if f, err := c.client.OpenFile(file, os.O_WRONLY|os.O_CREATE|os.O_TRUNC); err != nil {
+	part := file + ExtPart
+	f, err := c.client.OpenFile(part, os.O_WRONLY|os.O_CREATE|os.O_TRUNC)
+	if err != nil {
 		return 0, err
-	} else {
-		w = f
-		defer func() {
-			f.Close()
-			c.client.Chtimes(file, i.ModTime(), i.ModTime())
-		}()
 	}
-	if minify {
-		z, _ := gzip.NewWriterLevel(w, gzip.BestCompression)
-		defer z.Close()
+	defer func() {
+		f.Close()
+		c.client.Remove(part)
+	}()
 
+	var (
+		w io.Writer = f
+		z *gzip.Writer
+	)
+	if minify {
+		z, _ = gzip.NewWriterLevel(w, gzip.BestCompression)
 		w = z
 	}
 
@@ -180,9 +192,36 @@ func (c *Client) copy(r io.Reader, i os.FileInfo, file string, minify, integrity
 	if c1, c2 := local.Sum(nil), remote.Sum(nil); !bytes.Equal(c1[:], c2[:]) {
 		return n, fmt.Errorf("%w: %x - %x", ErrMismatched, c1, c2)
 	}
+	if z != nil {
+		if err := z.Close(); err != nil {
+			return n, err
+		}
+	}
+	if err := f.Close(); err != nil {
+		return n, err
+	}
+	c.client.Chtimes(part, i.ModTime(), i.ModTime())
+	if err := c.client.PosixRename(part, file); err != nil {
+		c.client.Remove(file)
+		if err := c.client.Rename(part, file); err != nil {
+			return n, err
+		}
+	}
 	return n, nil
 }
 
+type reader struct {
+	io.Reader
+	ctx context.Context
+}
+
+func (r reader) Read(b []byte) (int, error) {
+	if err := r.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return r.Reader.Read(b)
+}
+
 func (c *Client) Close() error {
 	if c.conn != nil {
 		c.conn.Close()
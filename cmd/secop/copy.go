@@ -5,6 +5,9 @@ import (
 	"fmt"
 	"io/ioutil"
 	"log"
+	"os"
+	"os/signal"
+	"syscall"
 
 	"github.com/midbel/cli"
 	"github.com/midbel/toml"
@@ -31,36 +34,38 @@ func runCopy(cmd *cli.Command, args []string) error {
 		return err
 	}
 
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+
 	var err error
 	if c.Jobs <= 1 {
-		err = singleJob(c.Credential, c.Directories, c.Buffer)
+		err = singleJob(ctx, c.Credential, c.Directories, c.Buffer)
 	} else {
-		err = multiJobs(c.Credential, c.Directories, c.Jobs, c.Buffer)
+		err = multiJobs(ctx, c.Credential, c.Directories, c.Jobs, c.Buffer)
 	}
 	return err
 }
 
-func multiJobs(c Credential, dirs []Directory, jobs, buffer int64) error {
-	var (
-		ctx  = context.TODO()
-		sema = semaphore.NewWeighted(jobs)
-	)
+func multiJobs(ctx context.Context, c Credential, dirs []Directory, jobs, buffer int64) error {
+	sema := semaphore.NewWeighted(jobs)
 	for i := range dirs {
 		if err := sema.Acquire(ctx, 1); err != nil {
-			return err
+			break
 		}
 		go func(d Directory) {
 			defer sema.Release(1)
-			if err := singleJob(c, []Directory{d}, buffer); err != nil {
+			if err := singleJob(ctx, c, []Directory{d}, buffer); err != nil {
 				log.Println(err)
 			}
 		}(dirs[i])
 	}
-
-	return sema.Acquire(ctx, jobs)
+	if err := sema.Acquire(context.Background(), jobs); err != nil {
+		return err
+	}
+	return ctx.Err()
 }
 
-func singleJob(c Credential, dirs []Directory, buffer int64) error {
+func singleJob(ctx context.Context, c Credential, dirs []Directory, buffer int64) error {
 	client, err := c.Connect()
 	if err != nil {
 		return err
@@ -70,7 +75,7 @@ func singleJob(c Credential, dirs []Directory, buffer int64) error {
 	}()
 
 	for _, d := range dirs {
-		if err := client.Copy(d, buffer); err != nil {
+		if err := client.Copy(ctx, d, buffer); err != nil {
 			return fmt.Errorf("fail to copy from %s to %s: %v", d.Local, d.Remote, err)
 		}
 	}
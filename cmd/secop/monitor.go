@@ -1,11 +1,15 @@
 package main
 
 import (
+	"context"
 	"encoding/binary"
 	"fmt"
 	"io"
 	"net"
+	"os"
+	"os/signal"
 	"path/filepath"
+	"syscall"
 
 	"github.com/midbel/cli"
 	"github.com/midbel/wip"
@@ -23,6 +27,13 @@ func runMonitor(cmd *cli.Command, args []string) error {
 	}
 	defer rc.Close()
 
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+	go func() {
+		<-ctx.Done()
+		rc.Close()
+	}()
+
 	var (
 		state State
 		file  string
@@ -30,6 +41,9 @@ func runMonitor(cmd *cli.Command, args []string) error {
 	)
 	for {
 		if err := binary.Read(rc, binary.BigEndian, &state); err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
 			return err
 		}
 		name := state.Filename()
@@ -40,7 +54,6 @@ func runMonitor(cmd *cli.Command, args []string) error {
 		file = name
 		bar.Update(state.Curr)
 	}
-	return nil
 }
 
 type Reader struct {
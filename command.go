@@ -2,13 +2,17 @@ package prospect
 
 import (
 	"bytes"
-	"crypto/md5"
+	"context"
 	"crypto/sha256"
+	"errors"
 	"fmt"
 	"io"
+	"io/ioutil"
+	"os"
 	"os/exec"
 	"path/filepath"
 	"sort"
+	"strconv"
 	"strings"
 	"time"
 )
@@ -17,9 +21,40 @@ const (
 	CmdName    = "command.name"
 	CmdArgs    = "command.args"
 	CmdVersion = "command.version"
+	CmdExit    = "command.exit"
+	CmdStderr  = "command.stderr"
 )
 
+const (
+	ExitSkip = "skip"
+	ExitKeep = "keep"
+	ExitFail = "fail"
+
+	StderrDiscard   = "discard"
+	StderrParameter = "parameter"
+	StderrProduct   = "product"
+)
+
+const TypeCommandError = "command error"
+
+const varOutdir = "{outdir}"
+
+var ErrCommand = errors.New("command failed")
+
+type Duration struct {
+	time.Duration
+}
+
+func (d *Duration) Set(str string) error {
+	v, err := time.ParseDuration(str)
+	if err == nil {
+		d.Duration = v
+	}
+	return err
+}
+
 type Command struct {
+	Name       string
 	Path       string
 	Version    string
 	Args       []string
@@ -27,53 +62,399 @@ type Command struct {
 	Type       string
 	Ext        string
 	Extensions []string
+
+	Timeout Duration `toml:"timeout"`
+	Env     []string `toml:"env"`
+	Workdir string   `toml:"workdir"`
+	Stdin   bool     `toml:"stdin"`
+	Stderr  string   `toml:"stderr"`
+	Exit    string   `toml:"exit"`
+	Output  string   `toml:"output"`
+}
+
+// OutputFile is a file produced by a command and the metadata of the product
+// to be created from it.
+type OutputFile struct {
+	Data Data
+	File string
+}
+
+// Output is the result of a command executed for a product. The files are
+// kept in a temporary directory until Close is called.
+type Output struct {
+	Files []OutputFile
+	dir   string
+}
+
+func (o Output) Close() error {
+	if o.dir == "" {
+		return nil
+	}
+	return os.RemoveAll(o.dir)
 }
 
+// Exec runs the command for d if it accepts the extension of d and gives the
+// first product created with its content. It is kept for compatibility with
+// the previous versions; use ExecContext to get all the products.
 func (c Command) Exec(d Data) (Data, []byte, error) {
 	if !c.can(filepath.Ext(d.File)) {
 		return d, nil, nil
 	}
+	out, err := c.ExecContext(context.Background(), d)
+	if err != nil {
+		return d, nil, err
+	}
+	defer out.Close()
+	if len(out.Files) == 0 {
+		return d, nil, nil
+	}
+	f := out.Files[0]
+	buf, err := ioutil.ReadFile(f.File)
+	return f.Data, buf, err
+}
+
+func (c Command) ExecContext(ctx context.Context, d Data) (Output, error) {
+	return c.execute(ctx, d, "")
+}
+
+// execute runs the command for d. If cache is set, the outputs of a successful
+// execution are kept in cache and reused by the next executions for the same
+// input, command, arguments and version of the command.
+func (c Command) execute(ctx context.Context, d Data, cache string) (Output, error) {
 	var (
-		args   = append(c.Args, d.File)
-		cmd    = exec.Command(c.Path, args...)
-		sumSHA = sha256.New()
-		sumMD5 = md5.New()
-		buf    bytes.Buffer
+		version = c.version(ctx)
+		dir     string
 	)
-	cmd.Stdout = io.MultiWriter(&buf, sumSHA, sumMD5)
-	err := cmd.Run()
+	if cache != "" && d.Sum != "" {
+		dir = filepath.Join(cache, c.key(d, version))
+		if code, err := readExit(dir); err == nil {
+			return c.output(d, dir, code, version)
+		}
+		if err := os.MkdirAll(cache, 0755); err != nil {
+			return Output{}, err
+		}
+	}
+	tmp, err := ioutil.TempDir(cache, ".prospect-")
 	if err != nil {
-		return d, nil, err
+		return Output{}, err
+	}
+	code, err := c.run(ctx, d, tmp)
+	if err == nil && dir != "" {
+		err = ioutil.WriteFile(filepath.Join(tmp, "exit"), []byte(strconv.Itoa(code)), 0644)
+		if err == nil {
+			if err = os.Rename(tmp, dir); err != nil {
+				if _, err = readExit(dir); err == nil {
+					os.RemoveAll(tmp)
+				}
+			}
+			if err == nil {
+				return c.output(d, dir, code, version)
+			}
+		}
+	}
+	if err != nil {
+		os.RemoveAll(tmp)
+		return Output{}, err
+	}
+	out, err := c.output(d, tmp, code, version)
+	if out.dir = tmp; err != nil {
+		out.Close()
+	}
+	return out, err
+}
+
+func (c Command) run(ctx context.Context, d Data, dir string) (int, error) {
+	outdir := filepath.Join(dir, "out")
+	if err := os.Mkdir(outdir, 0755); err != nil {
+		return 0, err
+	}
+	stdout, err := os.Create(filepath.Join(dir, "stdout"))
+	if err != nil {
+		return 0, err
+	}
+	defer stdout.Close()
+	stderr, err := os.Create(filepath.Join(dir, "stderr"))
+	if err != nil {
+		return 0, err
 	}
+	defer stderr.Close()
 
-	d.Integrity = SHA
-	d.Sum = fmt.Sprintf("%x", sumSHA.Sum(nil))
-	d.MD5 = fmt.Sprintf("%x", sumMD5.Sum(nil))
-	d.Size = int64(buf.Len())
+	if c.Timeout.Duration > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.Timeout.Duration)
+		defer cancel()
+	}
+	var (
+		args = c.arguments(d, outdir)
+		cmd  = exec.CommandContext(ctx, c.Path, args...)
+	)
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+	cmd.Dir = c.Workdir
+	if c.Stdin {
+		r, err := OpenFile(d.File)
+		if err != nil {
+			return 0, err
+		}
+		defer r.Close()
+		cmd.Stdin = r
+	}
+	if len(c.Env) > 0 {
+		cmd.Env = os.Environ()
+		for _, e := range c.Env {
+			cmd.Env = append(cmd.Env, c.expand(e, d, outdir))
+		}
+	}
+	err = cmd.Run()
+	switch err := ctx.Err(); {
+	case errors.Is(err, context.DeadlineExceeded):
+		msg, _ := ioutil.ReadFile(stderr.Name())
+		return 0, fmt.Errorf("%w: %s: timeout after %s: %s", ErrCommand, c.Path, c.Timeout.Duration, firstLine(msg))
+	case err != nil:
+		return 0, fmt.Errorf("%s: %w", c.Path, err)
+	}
+	var exit *exec.ExitError
+	switch {
+	case errors.As(err, &exit):
+		code := exit.ExitCode()
+		if !strings.EqualFold(c.Exit, ExitKeep) {
+			msg, _ := ioutil.ReadFile(stderr.Name())
+			return code, fmt.Errorf("%w: %s: exit status %d: %s", ErrCommand, c.Path, code, firstLine(msg))
+		}
+		return code, nil
+	case err != nil:
+		return 0, fmt.Errorf("%w: %s: %s", ErrCommand, c.Path, err)
+	}
+	return 0, nil
+}
+
+// output gives the products created from the files written in dir by the
+// command executed for d.
+func (c Command) output(d Data, dir string, code int, version string) (Output, error) {
+	var (
+		out    Output
+		stdout = filepath.Join(dir, "stdout")
+		stderr = filepath.Join(dir, "stderr")
+	)
+	if _, err := os.Stat(stdout); err != nil {
+		return out, err
+	}
+	// the creation time of the source product is kept so that running the
+	// command again gives the same metadata
 	d.Level = 1
 	d.Type = c.Type
 	d.Mime = c.Mime
-	d.File = d.File + c.Ext
-	d.ModTime = time.Now()
-
 	d.Register(CmdName, filepath.Base(c.Path))
 	if len(c.Args) > 0 {
 		d.Register(CmdArgs, strings.Join(c.Args, " "))
 	}
+	if code != 0 {
+		d.Register(CmdExit, code)
+	}
+	if version != "" {
+		d.Register(CmdVersion, version)
+	}
+	if strings.EqualFold(c.Stderr, StderrParameter) {
+		buf, err := ioutil.ReadFile(stderr)
+		if err != nil {
+			return out, err
+		}
+		if str := strings.TrimSpace(string(buf)); str != "" {
+			d.Register(CmdStderr, str)
+		}
+	}
+
+	var (
+		files []OutputFile
+		err   error
+	)
+	if c.Output == "" {
+		x := d.Clone()
+		x.File = d.File + c.Ext
+		files = append(files, OutputFile{Data: x, File: stdout})
+	} else if files, err = c.collect(d, filepath.Join(dir, "out")); err != nil {
+		return out, err
+	}
+	if strings.EqualFold(c.Stderr, StderrProduct) {
+		x := d.Clone()
+		x.Type = TypeCommandError
+		x.Mime = MimePlain
+		x.File = d.File + c.Ext + ".stderr"
+		files = append(files, OutputFile{Data: x, File: stderr})
+	}
+	for _, f := range files {
+		if err := readOutput(&f.Data, f.File); err != nil {
+			return out, err
+		}
+		if f.Data.Size > 0 {
+			out.Files = append(out.Files, f)
+		}
+	}
+	return out, nil
+}
+
+func (c Command) version(ctx context.Context) string {
+	if c.Version == "" {
+		return ""
+	}
+	buf, err := exec.CommandContext(ctx, c.Path, c.Version).Output()
+	if err != nil {
+		return ""
+	}
+	return string(bytes.Trim(buf, "\r\n"))
+}
 
-	if c.Version != "" {
-		cmd = exec.Command(c.Path, c.Version)
-		if buf, err := cmd.Output(); err == nil && len(buf) > 0 {
-			d.Register(CmdVersion, string(bytes.Trim(buf, "\r\n")))
+// key identifies the outputs of the command in the cache.
+func (c Command) key(d Data, version string) string {
+	var (
+		sum  = sha256.New()
+		list = []string{
+			d.Sum,
+			c.Path,
+			strings.Join(c.Args, "\x1f"),
+			strings.Join(c.Env, "\x1f"),
+			c.Output,
+			strconv.FormatBool(c.Stdin),
+			version,
 		}
+	)
+	io.WriteString(sum, strings.Join(list, "\x00"))
+	return fmt.Sprintf("%x", sum.Sum(nil))
+}
+
+func readExit(dir string) (int, error) {
+	buf, err := ioutil.ReadFile(filepath.Join(dir, "exit"))
+	if err != nil {
+		return 0, err
 	}
-	return d, buf.Bytes(), nil
+	return strconv.Atoi(string(buf))
 }
 
-// 114.74
+// collect gives the files written by the command in dir that match the output
+// pattern. Their names in the archive are relative to the directory of the
+// data file.
+func (c Command) collect(d Data, dir string) ([]OutputFile, error) {
+	list, err := filepath.Glob(filepath.Join(dir, c.Output))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(list)
+
+	var files []OutputFile
+	for _, f := range list {
+		i, err := os.Stat(f)
+		if err != nil {
+			return nil, err
+		}
+		if !i.Mode().IsRegular() {
+			continue
+		}
+		rel, err := filepath.Rel(dir, f)
+		if err != nil {
+			return nil, err
+		}
+		x := d.Clone()
+		x.File = filepath.Join(filepath.Dir(d.File), rel)
+		files = append(files, OutputFile{Data: x, File: f})
+	}
+	return files, nil
+}
+
+func readOutput(d *Data, file string) error {
+	r, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	if d.Mime == "" || d.Type == "" {
+		m, err := Sniff(r)
+		if err != nil {
+			return err
+		}
+		if d.Mime == "" {
+			d.Mime = m.Mime
+		}
+		if d.Type == "" {
+			d.Type = m.Type
+		}
+		if _, err := r.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+	}
+	return ReadFrom(d, r)
+}
+
+// arguments gives the arguments of the command with their placeholders
+// replaced. The path of the data file is added as last argument when none of
+// the arguments contains the {file} placeholder and it is not given on stdin.
+func (c Command) arguments(d Data, outdir string) []string {
+	var (
+		args = make([]string, 0, len(c.Args)+1)
+		used bool
+	)
+	for _, a := range c.Args {
+		if strings.Contains(a, varFile) {
+			used = true
+		}
+		args = append(args, c.expand(a, d, outdir))
+	}
+	if !used && !c.Stdin {
+		args = append(args, d.File)
+	}
+	return args
+}
+
+func (c Command) expand(str string, d Data, outdir string) string {
+	return strings.ReplaceAll(expand(str, d), varOutdir, outdir)
+}
+
+func (c Command) validate() error {
+	switch strings.ToLower(c.Exit) {
+	case "", ExitSkip, ExitKeep, ExitFail:
+	default:
+		return fmt.Errorf("%s: unknown exit policy", c.Exit)
+	}
+	switch strings.ToLower(c.Stderr) {
+	case "", StderrDiscard, StderrParameter, StderrProduct:
+	default:
+		return fmt.Errorf("%s: unknown stderr mode", c.Stderr)
+	}
+	return nil
+}
+
+func (c Command) name() string {
+	if c.Name != "" {
+		return c.Name
+	}
+	return filepath.Base(c.Path)
+}
+
+// accept tells if c should be executed for d. If the file section of d lists
+// commands, only those are executed and the extensions of the command are only
+// checked if any. Otherwise, the extension of d should be one of the
+// extensions of the command.
+func (c Command) accept(d Data) bool {
+	if len(d.Commands) == 0 {
+		return c.can(filepath.Ext(d.File))
+	}
+	for _, n := range d.Commands {
+		if n == c.name() {
+			return len(c.Extensions) == 0 || c.can(filepath.Ext(d.File))
+		}
+	}
+	return false
+}
 
 func (c Command) can(ext string) bool {
 	sort.Strings(c.Extensions)
 	x := sort.SearchStrings(c.Extensions, ext)
 	return x < len(c.Extensions) && c.Extensions[x] == ext
 }
+
+func firstLine(buf []byte) string {
+	buf = bytes.TrimSpace(buf)
+	if x := bytes.IndexByte(buf, '\n'); x >= 0 {
+		buf = buf[:x]
+	}
+	return string(bytes.TrimSpace(buf))
+}
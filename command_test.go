@@ -0,0 +1,79 @@
+package prospect
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+const cacheScript = `#!/bin/sh
+dir=$(dirname "$0")
+if [ "$1" = "--version" ]; then
+	cat "$dir/version"
+	exit 0
+fi
+echo run >> "$dir/runs"
+cat "$1"
+`
+
+func TestCommandCache(t *testing.T) {
+	dir, err := ioutil.TempDir("", "prospect-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	var (
+		script = filepath.Join(dir, "cmd.sh")
+		input  = filepath.Join(dir, "a.txt")
+		cache  = filepath.Join(dir, "cache")
+		c      = Command{Path: script, Version: "--version", Type: TypeText, Mime: MimePlain, Ext: ".out"}
+	)
+	if err := ioutil.WriteFile(script, []byte(cacheScript), 0755); err != nil {
+		t.Fatal(err)
+	}
+	data := []struct {
+		Name    string
+		Version string
+		Content string
+		Runs    int
+	}{
+		{Name: "first run", Version: "1.0", Content: "a", Runs: 1},
+		{Name: "same input", Version: "1.0", Content: "a", Runs: 1},
+		{Name: "new version", Version: "1.1", Content: "a", Runs: 2},
+		{Name: "same input and new version", Version: "1.1", Content: "a", Runs: 2},
+		{Name: "new input", Version: "1.1", Content: "b", Runs: 3},
+		{Name: "previous input", Version: "1.1", Content: "a", Runs: 3},
+	}
+	for _, x := range data {
+		if err := ioutil.WriteFile(filepath.Join(dir, "version"), []byte(x.Version), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(input, []byte(x.Content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		var d Data
+		if err := ReadFile(&d, input); err != nil {
+			t.Fatal(err)
+		}
+		out, err := c.execute(context.Background(), d, cache)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", x.Name, err)
+			continue
+		}
+		if len(out.Files) != 1 {
+			t.Errorf("%s: want 1 output, got %d", x.Name, len(out.Files))
+		} else if buf, _ := ioutil.ReadFile(out.Files[0].File); string(buf) != x.Content {
+			t.Errorf("%s: want output %q, got %q", x.Name, x.Content, buf)
+		}
+		out.Close()
+
+		buf, _ := ioutil.ReadFile(filepath.Join(dir, "runs"))
+		if runs := bytes.Count(buf, []byte("run\n")); runs != x.Runs {
+			t.Errorf("%s: want %d runs, got %d", x.Name, x.Runs, runs)
+		}
+	}
+}
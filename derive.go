@@ -0,0 +1,418 @@
+package prospect
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"os"
+	"strings"
+)
+
+const (
+	DeriveThumbnail = "thumbnail"
+	DerivePreview   = "preview"
+	DeriveFrame     = "frame"
+)
+
+const (
+	FormatPNG  = "png"
+	FormatJPEG = "jpeg"
+)
+
+var errUnsupported = errors.New("unsupported input")
+
+// Deriver creates a product (thumbnail, preview, first frame) from the content
+// of another product without relying on external commands.
+type Deriver struct {
+	Kind    string `toml:"kind"`
+	Size    int    `toml:"size"`
+	Format  string `toml:"format"`
+	Quality int    `toml:"quality"`
+	Type    string `toml:"type"`
+	Ext     string `toml:"ext"`
+}
+
+func (v Deriver) Derive(d Data) (Data, []byte, error) {
+	var (
+		img image.Image
+		err error
+	)
+	switch strings.ToLower(v.Kind) {
+	case DeriveThumbnail:
+		img, err = decodeImage(d)
+	case DerivePreview:
+		img, err = decodeRawImage(d)
+	case DeriveFrame:
+		img, err = decodeFirstFrame(d)
+	default:
+		err = fmt.Errorf("%s: unknown deriver", v.Kind)
+	}
+	if err != nil {
+		return d, nil, err
+	}
+	img = resizeImage(img, v.Size)
+
+	var buf bytes.Buffer
+	x := d.Clone()
+	x.ClearLinks()
+	switch strings.ToLower(v.Format) {
+	case "", FormatPNG:
+		x.Mime = MimePng
+		err = png.Encode(&buf, img)
+	case FormatJPEG, "jpg":
+		x.Mime = MimeJpeg
+		var opts jpeg.Options
+		if opts.Quality = v.Quality; opts.Quality <= 0 {
+			opts.Quality = jpeg.DefaultQuality
+		}
+		err = jpeg.Encode(&buf, img, &opts)
+	}
+	if err != nil {
+		return d, nil, err
+	}
+	x.Level = 1
+	x.Type = v.Type
+	if x.Type == "" {
+		x.Type = strings.ToLower(v.Kind)
+	}
+	x.File = d.File + v.extension()
+	if err := ReadFrom(&x, bytes.NewReader(buf.Bytes())); err != nil {
+		return d, nil, err
+	}
+	b := img.Bounds()
+	x.Register(ImageWidth, b.Dx())
+	x.Register(ImageHeight, b.Dy())
+	return x, buf.Bytes(), nil
+}
+
+func (v Deriver) extension() string {
+	if v.Ext != "" {
+		return v.Ext
+	}
+	ext := ".png"
+	switch strings.ToLower(v.Format) {
+	case FormatJPEG, "jpg":
+		ext = ".jpg"
+	}
+	return "." + strings.ToLower(v.Kind) + ext
+}
+
+func (v Deriver) validate() error {
+	switch strings.ToLower(v.Kind) {
+	case DeriveThumbnail, DerivePreview, DeriveFrame:
+	default:
+		return fmt.Errorf("%s: unknown deriver", v.Kind)
+	}
+	switch strings.ToLower(v.Format) {
+	case "", FormatPNG, FormatJPEG, "jpg":
+	default:
+		return fmt.Errorf("%s: unsupported format", v.Format)
+	}
+	return nil
+}
+
+func decodeImage(d Data) (image.Image, error) {
+	switch {
+	case strings.HasPrefix(d.Mime, MimePng), strings.HasPrefix(d.Mime, MimeJpeg):
+	default:
+		return nil, errUnsupported
+	}
+	r, err := OpenFile(d.File)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	img, _, err := image.Decode(r)
+	return img, err
+}
+
+// decodeRawImage decodes the Y800 and Y16 images produced by the hadock
+// cameras: a header (fourcc, sequence, time, width and height) followed by
+// the pixels. The size given by the header is checked against the size of the
+// file before the image is allocated.
+func decodeRawImage(d Data) (image.Image, error) {
+	size := d.Size
+	if size <= 0 {
+		i, err := os.Stat(d.File)
+		if err != nil {
+			return nil, err
+		}
+		size = i.Size()
+	}
+	r, err := OpenFile(d.File)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	c := struct {
+		FCC    [4]byte
+		Seq    uint32
+		Unix   uint64
+		Width  uint16
+		Height uint16
+	}{}
+	if err := binary.Read(r, binary.BigEndian, &c); err != nil {
+		return nil, err
+	}
+	var (
+		rect = image.Rect(0, 0, int(c.Width), int(c.Height))
+		need = int64(c.Width) * int64(c.Height)
+		img  image.Image
+		pix  []byte
+		swap bool
+	)
+	switch string(c.FCC[:]) {
+	case "Y800":
+	case "Y16 ", "Y16B", "Y16L":
+		need *= 2
+	default:
+		return nil, errUnsupported
+	}
+	if need > size-int64(binary.Size(c)) {
+		return nil, fmt.Errorf("%s: %dx%d image larger than file: %w", d.File, c.Width, c.Height, io.ErrUnexpectedEOF)
+	}
+	switch string(c.FCC[:]) {
+	case "Y800":
+		g := image.NewGray(rect)
+		pix, img = g.Pix, g
+	case "Y16L":
+		g := image.NewGray16(rect)
+		pix, img, swap = g.Pix, g, true
+	default:
+		g := image.NewGray16(rect)
+		pix, img = g.Pix, g
+	}
+	if _, err := io.ReadFull(r, pix); err != nil {
+		return nil, fmt.Errorf("%s: %w", d.File, err)
+	}
+	for i := 0; swap && i+1 < len(pix); i += 2 {
+		pix[i], pix[i+1] = pix[i+1], pix[i]
+	}
+	return img, nil
+}
+
+// decodeFirstFrame extracts the first sample of the video track of a
+// QuickTime file. Only the tracks encoded as JPEG or PNG images can be
+// decoded.
+func decodeFirstFrame(d Data) (image.Image, error) {
+	if !strings.HasPrefix(d.Mime, MimeQuick) {
+		return nil, errUnsupported
+	}
+	r, err := os.Open(d.File)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	s, err := r.Stat()
+	if err != nil {
+		return nil, err
+	}
+	top, err := readAtoms(r, 0, s.Size())
+	if err != nil {
+		return nil, err
+	}
+	moov, ok := findAtom(top, "moov")
+	if !ok {
+		return nil, fmt.Errorf("%s: moov atom not found", d.File)
+	}
+	traks, err := readAtoms(r, moov.offset, moov.end())
+	if err != nil {
+		return nil, err
+	}
+	for _, t := range traks {
+		if t.kind != "trak" {
+			continue
+		}
+		stbl, ok, err := videoTable(r, t)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			continue
+		}
+		offset, size, codec, err := firstSample(r, stbl)
+		if err != nil {
+			return nil, err
+		}
+		switch codec {
+		case "jpeg", "mjpa", "png ":
+		default:
+			return nil, errUnsupported
+		}
+		img, _, err := image.Decode(io.NewSectionReader(r, offset, size))
+		return img, err
+	}
+	return nil, errUnsupported
+}
+
+type atom struct {
+	kind   string
+	offset int64
+	size   int64
+}
+
+func (a atom) end() int64 {
+	return a.offset + a.size
+}
+
+func readAtoms(r io.ReaderAt, offset, end int64) ([]atom, error) {
+	var (
+		as  []atom
+		buf = make([]byte, 16)
+	)
+	for offset+8 <= end {
+		if _, err := r.ReadAt(buf[:8], offset); err != nil {
+			return nil, err
+		}
+		var (
+			size   = int64(binary.BigEndian.Uint32(buf))
+			header = int64(8)
+		)
+		switch size {
+		case 0:
+			size = end - offset
+		case 1:
+			if _, err := r.ReadAt(buf[8:], offset+8); err != nil {
+				return nil, err
+			}
+			size, header = int64(binary.BigEndian.Uint64(buf[8:])), 16
+		}
+		if size < header || offset+size > end {
+			return nil, fmt.Errorf("invalid atom size %d", size)
+		}
+		as = append(as, atom{kind: string(buf[4:8]), offset: offset + header, size: size - header})
+		offset += size
+	}
+	return as, nil
+}
+
+func findAtom(as []atom, kind string) (atom, bool) {
+	for _, a := range as {
+		if a.kind == kind {
+			return a, true
+		}
+	}
+	return atom{}, false
+}
+
+func findPath(r io.ReaderAt, a atom, kinds ...string) (atom, bool, error) {
+	for _, k := range kinds {
+		as, err := readAtoms(r, a.offset, a.end())
+		if err != nil {
+			return a, false, err
+		}
+		var ok bool
+		if a, ok = findAtom(as, k); !ok {
+			return a, false, nil
+		}
+	}
+	return a, true, nil
+}
+
+func videoTable(r io.ReaderAt, trak atom) (atom, bool, error) {
+	hdlr, ok, err := findPath(r, trak, "mdia", "hdlr")
+	if err != nil || !ok {
+		return hdlr, ok, err
+	}
+	buf := make([]byte, 12)
+	if _, err := r.ReadAt(buf, hdlr.offset); err != nil {
+		return hdlr, false, err
+	}
+	if string(buf[8:]) != "vide" {
+		return hdlr, false, nil
+	}
+	return findPath(r, trak, "mdia", "minf", "stbl")
+}
+
+func firstSample(r io.ReaderAt, stbl atom) (int64, int64, string, error) {
+	as, err := readAtoms(r, stbl.offset, stbl.end())
+	if err != nil {
+		return 0, 0, "", err
+	}
+	read := func(kind string, size int) ([]byte, error) {
+		a, ok := findAtom(as, kind)
+		if !ok || a.size < int64(size) {
+			return nil, fmt.Errorf("%s atom not found", kind)
+		}
+		buf := make([]byte, size)
+		_, err := r.ReadAt(buf, a.offset)
+		return buf, err
+	}
+	stsd, err := read("stsd", 16)
+	if err != nil {
+		return 0, 0, "", err
+	}
+	stsz, err := read("stsz", 12)
+	if err != nil {
+		return 0, 0, "", err
+	}
+	size := int64(binary.BigEndian.Uint32(stsz[4:]))
+	if size == 0 {
+		if stsz, err = read("stsz", 16); err != nil {
+			return 0, 0, "", err
+		}
+		size = int64(binary.BigEndian.Uint32(stsz[12:]))
+	}
+	var offset int64
+	if stco, err := read("stco", 12); err == nil {
+		offset = int64(binary.BigEndian.Uint32(stco[8:]))
+	} else if co64, err := read("co64", 16); err == nil {
+		offset = int64(binary.BigEndian.Uint64(co64[8:]))
+	} else {
+		return 0, 0, "", err
+	}
+	return offset, size, string(stsd[12:16]), nil
+}
+
+// resizeImage scales img down (averaging the pixels of the source) so that
+// its largest dimension is size. Images already smaller are returned as is.
+func resizeImage(img image.Image, size int) image.Image {
+	var (
+		b = img.Bounds()
+		w = b.Dx()
+		h = b.Dy()
+	)
+	if size <= 0 || (w <= size && h <= size) {
+		return img
+	}
+	dw, dh := size, h*size/w
+	if h > w {
+		dw, dh = w*size/h, size
+	}
+	if dw == 0 {
+		dw = 1
+	}
+	if dh == 0 {
+		dh = 1
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+	for y := 0; y < dh; y++ {
+		y0, y1 := b.Min.Y+y*h/dh, b.Min.Y+(y+1)*h/dh
+		for x := 0; x < dw; x++ {
+			var (
+				x0, x1     = b.Min.X + x*w/dw, b.Min.X + (x+1)*w/dw
+				r, g, c, a uint64
+				n          uint64
+			)
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					pr, pg, pb, pa := img.At(sx, sy).RGBA()
+					r, g, c, a = r+uint64(pr), g+uint64(pg), c+uint64(pb), a+uint64(pa)
+					n++
+				}
+			}
+			if n == 0 {
+				continue
+			}
+			dst.SetRGBA(x, y, color.RGBA{R: uint8(r / n >> 8), G: uint8(g / n >> 8), B: uint8(c / n >> 8), A: uint8(a / n >> 8)})
+		}
+	}
+	return dst
+}
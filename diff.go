@@ -0,0 +1,126 @@
+package prospect
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+type Change struct {
+	Name string `json:"name"`
+	Old  string `json:"old,omitempty"`
+	New  string `json:"new,omitempty"`
+}
+
+type Diff struct {
+	Time    time.Time `json:"time"`
+	File    string    `json:"file"`
+	Changes []Change  `json:"changes"`
+}
+
+func DiffMeta(old, new []byte) ([]Change, error) {
+	prev, err := flattenMeta(old)
+	if err != nil {
+		return nil, err
+	}
+	next, err := flattenMeta(new)
+	if err != nil {
+		return nil, err
+	}
+	var cs []Change
+	for k, v := range prev {
+		if n, ok := next[k]; !ok || n != v {
+			cs = append(cs, Change{Name: k, Old: v, New: n})
+		}
+	}
+	for k, v := range next {
+		if _, ok := prev[k]; !ok {
+			cs = append(cs, Change{Name: k, New: v})
+		}
+	}
+	sort.Slice(cs, func(i, j int) bool {
+		return cs[i].Name < cs[j].Name
+	})
+	return cs, nil
+}
+
+func (a Archive) logDiff(file string, old, new []byte) error {
+	if a.DiffLog == "" {
+		return nil
+	}
+	cs, err := DiffMeta(old, new)
+	if err != nil || len(cs) == 0 {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(a.DiffLog), 0755); err != nil {
+		return err
+	}
+	w, err := os.OpenFile(a.DiffLog, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	defer w.Close()
+
+	d := Diff{
+		Time:    time.Now().UTC(),
+		File:    file,
+		Changes: cs,
+	}
+	return json.NewEncoder(w).Encode(d)
+}
+
+func flattenMeta(buf []byte) (map[string]string, error) {
+	var n node
+	if err := xml.NewDecoder(bytes.NewReader(buf)).Decode(&n); err != nil {
+		return nil, err
+	}
+	vs := make(map[string]string)
+	n.flatten("", vs)
+	return vs, nil
+}
+
+func (n node) flatten(prefix string, vs map[string]string) {
+	if len(n.Nodes) == 0 {
+		vs[prefix] = strings.TrimSpace(n.Text)
+		return
+	}
+	seen := make(map[string]int)
+	for _, c := range n.Nodes {
+		if name, value, ok := c.pair(); ok {
+			vs[joinKey(prefix, name)] = value
+			continue
+		}
+		key := c.XMLName.Local
+		if i := seen[key]; i > 0 {
+			key = fmt.Sprintf("%s[%d]", key, i)
+		}
+		seen[c.XMLName.Local]++
+		c.flatten(joinKey(prefix, key), vs)
+	}
+}
+
+func (n node) pair() (string, string, bool) {
+	var name, value string
+	for _, c := range n.Nodes {
+		switch c.XMLName.Local {
+		case "name":
+			name = strings.TrimSpace(c.Text)
+		case "value":
+			value = strings.TrimSpace(c.Text)
+		}
+	}
+	return name, value, name != ""
+}
+
+func joinKey(prefix, key string) string {
+	if prefix == "" {
+		return key
+	}
+	return prefix + "/" + key
+}
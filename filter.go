@@ -0,0 +1,373 @@
+package prospect
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+var ErrSyntax = errors.New("syntax error")
+
+type Filter interface {
+	Match(Data) bool
+}
+
+type FilterFunc func(Data) bool
+
+func (f FilterFunc) Match(d Data) bool {
+	return f(d)
+}
+
+func ParseFilter(str string) (Filter, error) {
+	if strings.TrimSpace(str) == "" {
+		return FilterFunc(func(_ Data) bool { return true }), nil
+	}
+	ts, err := tokenize(str)
+	if err != nil {
+		return nil, err
+	}
+	p := parser{tokens: ts}
+	f, err := p.parseOr()
+	if err == nil && !p.done() {
+		err = fmt.Errorf("%w: unexpected %q", ErrSyntax, p.peek().Literal)
+	}
+	return f, err
+}
+
+const (
+	kindWord rune = iota
+	kindString
+	kindOperator
+	kindLparen
+	kindRparen
+)
+
+type token struct {
+	Literal string
+	Kind    rune
+}
+
+func tokenize(str string) ([]token, error) {
+	var (
+		ts []token
+		rs = []rune(str)
+	)
+	for i := 0; i < len(rs); {
+		switch r := rs[i]; {
+		case unicode.IsSpace(r):
+			i++
+		case r == '(':
+			ts = append(ts, token{Literal: "(", Kind: kindLparen})
+			i++
+		case r == ')':
+			ts = append(ts, token{Literal: ")", Kind: kindRparen})
+			i++
+		case r == '"' || r == '\'':
+			j := i + 1
+			for j < len(rs) && rs[j] != r {
+				if rs[j] == '\\' {
+					j++
+				}
+				j++
+			}
+			if j >= len(rs) {
+				return nil, fmt.Errorf("%w: unterminated string", ErrSyntax)
+			}
+			lit := strings.NewReplacer(`\`+string(r), string(r), `\\`, `\`).Replace(string(rs[i+1 : j]))
+			ts = append(ts, token{Literal: lit, Kind: kindString})
+			i = j + 1
+		case isOperator(r):
+			j := i
+			for j < len(rs) && isOperator(rs[j]) {
+				j++
+			}
+			ts = append(ts, token{Literal: string(rs[i:j]), Kind: kindOperator})
+			i = j
+		default:
+			j := i
+			for j < len(rs) && !unicode.IsSpace(rs[j]) && !isOperator(rs[j]) && !isDelimiter(rs[j]) {
+				j++
+			}
+			ts = append(ts, token{Literal: string(rs[i:j]), Kind: kindWord})
+			i = j
+		}
+	}
+	return ts, nil
+}
+
+func isOperator(r rune) bool {
+	return r == '=' || r == '!' || r == '<' || r == '>' || r == '~'
+}
+
+func isDelimiter(r rune) bool {
+	return r == '(' || r == ')' || r == '"' || r == '\''
+}
+
+type parser struct {
+	tokens []token
+	curr   int
+}
+
+func (p *parser) parseOr() (Filter, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.isKeyword("or") {
+		p.curr++
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = or{left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *parser) parseAnd() (Filter, error) {
+	left, err := p.parseNot()
+	if err != nil {
+		return nil, err
+	}
+	for p.isKeyword("and") {
+		p.curr++
+		right, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		left = and{left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *parser) parseNot() (Filter, error) {
+	if p.isKeyword("not") {
+		p.curr++
+		f, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		return not{inner: f}, nil
+	}
+	if !p.done() && p.peek().Kind == kindLparen {
+		p.curr++
+		f, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if p.done() || p.peek().Kind != kindRparen {
+			return nil, fmt.Errorf("%w: missing closing parenthesis", ErrSyntax)
+		}
+		p.curr++
+		return f, nil
+	}
+	return p.parseComparison()
+}
+
+func (p *parser) parseComparison() (Filter, error) {
+	if len(p.tokens)-p.curr < 3 {
+		return nil, fmt.Errorf("%w: incomplete comparison", ErrSyntax)
+	}
+	var (
+		field = p.tokens[p.curr]
+		op    = p.tokens[p.curr+1]
+		value = p.tokens[p.curr+2]
+	)
+	if field.Kind != kindWord {
+		return nil, fmt.Errorf("%w: unexpected %q, field name expected", ErrSyntax, field.Literal)
+	}
+	if op.Kind != kindOperator {
+		return nil, fmt.Errorf("%w: unexpected %q, operator expected", ErrSyntax, op.Literal)
+	}
+	if value.Kind != kindWord && value.Kind != kindString {
+		return nil, fmt.Errorf("%w: unexpected %q, value expected", ErrSyntax, value.Literal)
+	}
+	p.curr += 3
+
+	c := comparison{
+		field: field.Literal,
+		op:    op.Literal,
+		value: value.Literal,
+	}
+	switch c.op {
+	case "=", "==", "!=", "<", "<=", ">", ">=":
+	case "~", "!~":
+		re, err := regexp.Compile(c.value)
+		if err != nil {
+			return nil, err
+		}
+		c.re = re
+	default:
+		return nil, fmt.Errorf("%w: unknown operator %q", ErrSyntax, c.op)
+	}
+	return c, nil
+}
+
+func (p *parser) isKeyword(kw string) bool {
+	if p.done() {
+		return false
+	}
+	t := p.peek()
+	return t.Kind == kindWord && strings.EqualFold(t.Literal, kw)
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.curr]
+}
+
+func (p *parser) done() bool {
+	return p.curr >= len(p.tokens)
+}
+
+type and struct {
+	left, right Filter
+}
+
+func (a and) Match(d Data) bool {
+	return a.left.Match(d) && a.right.Match(d)
+}
+
+type or struct {
+	left, right Filter
+}
+
+func (o or) Match(d Data) bool {
+	return o.left.Match(d) || o.right.Match(d)
+}
+
+type not struct {
+	inner Filter
+}
+
+func (n not) Match(d Data) bool {
+	return !n.inner.Match(d)
+}
+
+type comparison struct {
+	field string
+	op    string
+	value string
+	re    *regexp.Regexp
+}
+
+func (c comparison) Match(d Data) bool {
+	var (
+		vs, kind = lookupField(d, c.field)
+		negate   = c.op == "!=" || c.op == "!~"
+	)
+	for _, v := range vs {
+		if c.match(v, kind) {
+			return !negate
+		}
+	}
+	return negate
+}
+
+func (c comparison) match(v, kind string) bool {
+	switch c.op {
+	case "~", "!~":
+		return c.re.MatchString(v)
+	}
+	cmp, ok := compareValues(v, c.value, kind)
+	if !ok {
+		return false
+	}
+	switch c.op {
+	case "=", "==", "!=":
+		return cmp == 0
+	case "<":
+		return cmp < 0
+	case "<=":
+		return cmp <= 0
+	case ">":
+		return cmp > 0
+	case ">=":
+		return cmp >= 0
+	}
+	return false
+}
+
+func compareValues(left, right, kind string) (int, bool) {
+	switch kind {
+	case TypeDatetime:
+		lt, err1 := parseTime(left)
+		rt, err2 := parseTime(right)
+		if err1 != nil || err2 != nil {
+			return 0, false
+		}
+		switch {
+		case lt.Before(rt):
+			return -1, true
+		case lt.After(rt):
+			return 1, true
+		default:
+			return 0, true
+		}
+	case TypeInt, TypeFloat, "":
+		lf, err1 := strconv.ParseFloat(left, 64)
+		rf, err2 := strconv.ParseFloat(right, 64)
+		if err1 == nil && err2 == nil {
+			switch {
+			case lf < rf:
+				return -1, true
+			case lf > rf:
+				return 1, true
+			default:
+				return 0, true
+			}
+		}
+	}
+	left, right = strings.ToLower(left), strings.ToLower(right)
+	return strings.Compare(left, right), true
+}
+
+func lookupField(d Data, field string) ([]string, string) {
+	switch strings.ToLower(field) {
+	case "experiment":
+		return []string{d.Experiment}, TypeString
+	case "model":
+		return []string{d.Model}, TypeString
+	case "source":
+		return []string{d.Source}, TypeString
+	case "owner":
+		return []string{d.Owner}, TypeString
+	case "type":
+		return []string{d.Type}, TypeString
+	case "mime", "format":
+		return []string{d.Mime}, TypeString
+	case "file", "path":
+		return []string{d.File}, TypeString
+	case "level":
+		return []string{strconv.Itoa(d.Level)}, TypeInt
+	case "size", fileSize:
+		return []string{strconv.FormatInt(d.Size, 10)}, TypeInt
+	case "md5", fileMD5:
+		return []string{d.MD5}, TypeString
+	case "checksum", "sum":
+		return []string{d.Sum}, TypeString
+	case "acqtime":
+		return []string{d.AcqTime.Format(time.RFC3339)}, TypeDatetime
+	case "modtime":
+		return []string{d.ModTime.Format(time.RFC3339)}, TypeDatetime
+	case "increment", "increments":
+		return d.Increments, TypeString
+	case "crew", "crews":
+		return d.Crews, TypeString
+	case "link", "links":
+		vs := make([]string, len(d.Links))
+		for i := range d.Links {
+			vs[i] = d.Links[i].File
+		}
+		return vs, TypeString
+	}
+	for _, p := range d.Parameters {
+		if p.Name == field || strings.EqualFold(p.Name, field) {
+			return []string{p.Value}, p.Type
+		}
+	}
+	return nil, ""
+}
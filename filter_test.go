@@ -0,0 +1,95 @@
+package prospect
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestParseFilter(t *testing.T) {
+	data := []struct {
+		Input string
+		Err   error
+	}{
+		{Input: ""},
+		{Input: "type = image"},
+		{Input: "type=image"},
+		{Input: `type = "line camera image"`},
+		{Input: `type = 'it''s'`, Err: ErrSyntax},
+		{Input: "level >= 1 and not (type = image or type = video)"},
+		{Input: "file ~ '^a.*\\.txt$'"},
+		{Input: "type = image and", Err: ErrSyntax},
+		{Input: "(type = image", Err: ErrSyntax},
+		{Input: "type = image)", Err: ErrSyntax},
+		{Input: "type image", Err: ErrSyntax},
+		{Input: "type <> image", Err: ErrSyntax},
+		{Input: `type = "image`, Err: ErrSyntax},
+		{Input: "= image", Err: ErrSyntax},
+	}
+	for _, d := range data {
+		_, err := ParseFilter(d.Input)
+		switch {
+		case d.Err == nil && err != nil:
+			t.Errorf("%s: unexpected error: %s", d.Input, err)
+		case d.Err != nil && !errors.Is(err, d.Err):
+			t.Errorf("%s: want %s, got %v", d.Input, d.Err, err)
+		}
+	}
+}
+
+func TestFilterMatch(t *testing.T) {
+	d := Data{
+		Experiment: "FSL",
+		Type:       "Line Camera Image",
+		Mime:       MimePng,
+		File:       "images/a_001.png",
+		Level:      1,
+		Size:       2048,
+		AcqTime:    time.Date(2021, 3, 1, 12, 0, 0, 0, time.UTC),
+		Increments: []string{"64", "65"},
+		Links:      []Link{CreateLink("data/a.dat", "data")},
+		Parameters: []Parameter{
+			MakeParameter("hpkt.vmu2.upi", "CAL_LEFT"),
+			MakeParameter("image.width", 640),
+			MakeParameter("scienceRun", "R1"),
+		},
+	}
+	data := []struct {
+		Input string
+		Want  bool
+	}{
+		{Input: "", Want: true},
+		{Input: `type = "line camera image"`, Want: true},
+		{Input: "type != image", Want: true},
+		{Input: "experiment = fsl and level = 1", Want: true},
+		{Input: "experiment = fsl and level = 2", Want: false},
+		{Input: "level = 2 or size > 1024", Want: true},
+		{Input: "not level = 1", Want: false},
+		{Input: "not (level = 2 or size < 1024)", Want: true},
+		{Input: "size >= 2048 and size <= 2048", Want: true},
+		{Input: "image.width > 100", Want: true},
+		{Input: "image.width > 1000", Want: false},
+		{Input: "acqtime > 2021-03-01", Want: true},
+		{Input: "acqtime < 2021-03-01T12:00:00Z", Want: false},
+		{Input: "increment = 65", Want: true},
+		{Input: "increment = 66", Want: false},
+		{Input: "increment != 66", Want: true},
+		{Input: "link = data/a.dat", Want: true},
+		{Input: "hpkt.vmu2.upi ~ CAL", Want: true},
+		{Input: "HPKT.VMU2.UPI ~ '^CAL_'", Want: true},
+		{Input: "file !~ '\\.png$'", Want: false},
+		{Input: "scienceRun = r1", Want: true},
+		{Input: "unknown = value", Want: false},
+		{Input: "unknown != value", Want: true},
+	}
+	for _, x := range data {
+		f, err := ParseFilter(x.Input)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", x.Input, err)
+			continue
+		}
+		if got := f.Match(d); got != x.Want {
+			t.Errorf("%s: want %t, got %t", x.Input, x.Want, got)
+		}
+	}
+}
@@ -0,0 +1,280 @@
+package prospect
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	GroupType      = "type"
+	GroupIncrement = "increment"
+	GroupDay       = "day"
+)
+
+type Entry struct {
+	File         string    `json:"relativePath" xml:"relativePath"`
+	Experiment   string    `json:"experiment" xml:"experimentName"`
+	Type         string    `json:"type" xml:"productType"`
+	Level        int       `json:"level" xml:"processingLevel"`
+	Mime         string    `json:"mime" xml:"fileFormat"`
+	AcqTime      time.Time `json:"acqtime" xml:"acquisitionTime"`
+	ModTime      time.Time `json:"modtime" xml:"creationTime"`
+	Increments   []string  `json:"increments" xml:"increments>increment"`
+	Size         int64     `json:"size" xml:"size"`
+	Integrity    string    `json:"integrity" xml:"integrity>method"`
+	Sum          string    `json:"checksum" xml:"integrity>value"`
+	MD5          string    `json:"md5,omitempty" xml:"md5,omitempty"`
+	Supersedes   string    `json:"supersedes,omitempty" xml:"supersedes,omitempty"`
+	SupersededBy string    `json:"supersededBy,omitempty" xml:"supersededBy,omitempty"`
+}
+
+func NewEntry(d Data) Entry {
+	e := Entry{
+		File:       d.File,
+		Experiment: d.Experiment,
+		Type:       d.Type,
+		Level:      d.Level,
+		Mime:       d.Mime,
+		AcqTime:    d.AcqTime,
+		ModTime:    d.ModTime,
+		Increments: d.Increments,
+		Size:       d.Size,
+		Integrity:  d.Integrity,
+		Sum:        d.Sum,
+		MD5:        d.MD5,
+	}
+	for _, k := range d.Links {
+		switch k.Role {
+		case RoleSupersedes:
+			e.Supersedes = k.File
+		case RoleSupersededBy:
+			e.SupersededBy = k.File
+		}
+	}
+	return e
+}
+
+type Inventory []Entry
+
+func ReadInventory(ctx context.Context, dir string, accept AcceptFunc) (Inventory, error) {
+	var es Inventory
+	err := WalkMeta(ctx, dir, func(_ string, d Data) error {
+		if accept == nil || accept(d) {
+			es = append(es, NewEntry(d))
+		}
+		return nil
+	})
+	sort.Slice(es, func(i, j int) bool {
+		return es[i].File < es[j].File
+	})
+	return es, err
+}
+
+func (iv Inventory) EncodeCSV(w io.Writer) error {
+	ws := csv.NewWriter(w)
+	ws.Write([]string{"relativePath", "experiment", "type", "level", "mime", "acqtime", "modtime", "increments", "size", "integrity", "checksum", "md5", "supersedes", "superseded-by"})
+	for _, e := range iv {
+		row := []string{
+			e.File,
+			e.Experiment,
+			e.Type,
+			strconv.Itoa(e.Level),
+			e.Mime,
+			e.AcqTime.Format(time.RFC3339),
+			e.ModTime.Format(time.RFC3339),
+			strings.Join(e.Increments, ";"),
+			strconv.FormatInt(e.Size, 10),
+			e.Integrity,
+			e.Sum,
+			e.MD5,
+			e.Supersedes,
+			e.SupersededBy,
+		}
+		ws.Write(row)
+	}
+	ws.Flush()
+	return ws.Error()
+}
+
+func (iv Inventory) EncodeJSON(w io.Writer) error {
+	e := json.NewEncoder(w)
+	e.SetIndent("", "  ")
+	return e.Encode(iv)
+}
+
+// WriteFile writes the inventory to file in the format given by its
+// extension (.csv, .json or .xml).
+func (iv Inventory) WriteFile(file string) error {
+	return writeEncoder(file, "", iv)
+}
+
+// WriteFileAs writes the inventory to file in the given format. If format is
+// empty, it is given by the extension of file.
+func (iv Inventory) WriteFileAs(file, format string) error {
+	return writeEncoder(file, format, iv)
+}
+
+// Write writes the inventory to w in the given format (csv, json or xml).
+func (iv Inventory) Write(w io.Writer, format string) error {
+	return encodeAs(w, format, iv)
+}
+
+func (iv Inventory) EncodeXML(w io.Writer) error {
+	doc := struct {
+		XMLName  xml.Name `xml:"inventory"`
+		Products []Entry  `xml:"product"`
+	}{
+		Products: iv,
+	}
+	return encodeDocument(w, doc)
+}
+
+type Stat struct {
+	Group string    `json:"group" xml:"group,attr"`
+	Key   string    `json:"key" xml:"key,attr"`
+	Count int       `json:"count" xml:"count"`
+	Size  int64     `json:"size" xml:"size"`
+	First time.Time `json:"first" xml:"first"`
+	Last  time.Time `json:"last" xml:"last"`
+}
+
+func (s *Stat) update(e Entry) {
+	if s.Count == 0 || e.AcqTime.Before(s.First) {
+		s.First = e.AcqTime
+	}
+	if s.Count == 0 || e.AcqTime.After(s.Last) {
+		s.Last = e.AcqTime
+	}
+	s.Count++
+	s.Size += e.Size
+}
+
+type Statistics []Stat
+
+func (iv Inventory) Statistics() Statistics {
+	var (
+		set  = make(map[[2]string]*Stat)
+		keys = func(e Entry) [][2]string {
+			ks := [][2]string{
+				{GroupType, e.Type},
+				{GroupDay, e.AcqTime.Format("2006-01-02")},
+			}
+			for _, i := range e.Increments {
+				ks = append(ks, [2]string{GroupIncrement, i})
+			}
+			if len(e.Increments) == 0 {
+				ks = append(ks, [2]string{GroupIncrement, ""})
+			}
+			return ks
+		}
+	)
+	for _, e := range iv {
+		for _, k := range keys(e) {
+			s, ok := set[k]
+			if !ok {
+				s = &Stat{Group: k[0], Key: k[1]}
+				set[k] = s
+			}
+			s.update(e)
+		}
+	}
+	ss := make(Statistics, 0, len(set))
+	for _, s := range set {
+		ss = append(ss, *s)
+	}
+	sort.Slice(ss, func(i, j int) bool {
+		if ss[i].Group == ss[j].Group {
+			return ss[i].Key < ss[j].Key
+		}
+		return ss[i].Group < ss[j].Group
+	})
+	return ss
+}
+
+// WriteFile writes the statistics to file in the format given by its
+// extension (.csv, .json or .xml).
+func (ss Statistics) WriteFile(file string) error {
+	return writeEncoder(file, "", ss)
+}
+
+// WriteFileAs writes the statistics to file in the given format. If format is
+// empty, it is given by the extension of file.
+func (ss Statistics) WriteFileAs(file, format string) error {
+	return writeEncoder(file, format, ss)
+}
+
+// Write writes the statistics to w in the given format (csv, json or xml).
+func (ss Statistics) Write(w io.Writer, format string) error {
+	return encodeAs(w, format, ss)
+}
+
+func (ss Statistics) EncodeCSV(w io.Writer) error {
+	ws := csv.NewWriter(w)
+	ws.Write([]string{"group", "key", "count", "size", "first", "last"})
+	for _, s := range ss {
+		row := []string{
+			s.Group,
+			s.Key,
+			strconv.Itoa(s.Count),
+			strconv.FormatInt(s.Size, 10),
+			s.First.Format(time.RFC3339),
+			s.Last.Format(time.RFC3339),
+		}
+		ws.Write(row)
+	}
+	ws.Flush()
+	return ws.Error()
+}
+
+func (ss Statistics) EncodeJSON(w io.Writer) error {
+	e := json.NewEncoder(w)
+	e.SetIndent("", "  ")
+	return e.Encode(ss)
+}
+
+func (ss Statistics) EncodeXML(w io.Writer) error {
+	doc := struct {
+		XMLName xml.Name `xml:"statistics"`
+		Stats   []Stat   `xml:"stat"`
+	}{
+		Stats: ss,
+	}
+	return encodeDocument(w, doc)
+}
+
+type encoder interface {
+	EncodeCSV(io.Writer) error
+	EncodeJSON(io.Writer) error
+	EncodeXML(io.Writer) error
+}
+
+func writeEncoder(file, format string, e encoder) error {
+	if format == "" {
+		format = strings.TrimPrefix(strings.ToLower(filepath.Ext(file)), ".")
+	}
+	return writeFile(file, func(w io.Writer) error {
+		return encodeAs(w, format, e)
+	})
+}
+
+func encodeAs(w io.Writer, format string, e encoder) error {
+	switch strings.ToLower(format) {
+	case "csv":
+		return e.EncodeCSV(w)
+	case "json":
+		return e.EncodeJSON(w)
+	case "xml":
+		return e.EncodeXML(w)
+	default:
+		return fmt.Errorf("%s: unsupported inventory format", format)
+	}
+}
@@ -0,0 +1,364 @@
+package prospect
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+type relation struct {
+	from string
+	to   string
+	role string
+}
+
+// graph collects the relations between the products stored during a run in
+// order to write the reciprocal links once all products have been stored.
+type graph struct {
+	mu        sync.Mutex
+	paths     map[string]string
+	stored    map[string]Data
+	restored  map[string]bool
+	relations []relation
+}
+
+func newGraph() *graph {
+	return &graph{
+		paths:    make(map[string]string),
+		stored:   make(map[string]Data),
+		restored: make(map[string]bool),
+	}
+}
+
+// restore adds to g the products stored and the relations of an interrupted
+// run.
+func (g *graph) restore(files []string, rs []relation) {
+	for _, f := range files {
+		g.paths[f] = f
+		g.restored[f] = true
+	}
+	g.relations = append(g.relations, rs...)
+}
+
+// pending gives the products stored and the relations registered during the
+// run with the paths of the products in the archive.
+func (g *graph) pending() ([]string, []relation) {
+	if g == nil {
+		return nil, nil
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	var (
+		files []string
+		rs    []relation
+		seen  = make(map[string]bool)
+	)
+	for _, f := range g.paths {
+		if !seen[f] {
+			seen[f] = true
+			files = append(files, f)
+		}
+	}
+	sort.Strings(files)
+	for _, r := range g.relations {
+		from, ok := g.paths[r.from]
+		if !ok {
+			continue
+		}
+		to, ok := g.paths[r.to]
+		if !ok {
+			to = r.to
+		}
+		if from != to {
+			rs = append(rs, relation{from: from, to: to, role: r.role})
+		}
+	}
+	return files, rs
+}
+
+// Register records that d has been stored as x (d with its version) at file.
+func (g *graph) Register(d, x Data, file string) {
+	if g == nil {
+		return
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	g.paths[file] = file
+	g.paths[d.File] = file
+	g.paths[d.Path()] = file
+	for _, k := range d.Links {
+		g.relations = append(g.relations, relation{from: file, to: k.File, role: k.Role})
+	}
+	g.stored[file] = x
+}
+
+func (g *graph) Relate(from, to Data, role string) {
+	if g == nil {
+		return
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.relations = append(g.relations, relation{from: from.File, to: to.File, role: role})
+}
+
+// LinkRule links the products selected by From to the products selected by
+// To that share the same values for the fields given in On and, if Within is
+// set, whose time range contains the acquisition time of the former.
+type LinkRule struct {
+	From   string   `toml:"from"`
+	To     string   `toml:"to"`
+	On     []string `toml:"on"`
+	Within bool     `toml:"within"`
+	Role   string   `toml:"role"`
+
+	from Filter
+	to   Filter
+}
+
+func (r *LinkRule) compile() error {
+	var err error
+	if r.from, err = ParseFilter(r.From); err != nil {
+		return fmt.Errorf("link(from): %w", err)
+	}
+	if r.to, err = ParseFilter(r.To); err != nil {
+		return fmt.Errorf("link(to): %w", err)
+	}
+	if len(r.On) == 0 && !r.Within {
+		return fmt.Errorf("link: on or within should be set")
+	}
+	return nil
+}
+
+func (r LinkRule) key(d Data) (string, bool) {
+	ks := make([]string, 0, len(r.On))
+	for _, f := range r.On {
+		vs, _ := lookupField(d, f)
+		if len(vs) == 0 {
+			return "", false
+		}
+		vs = append([]string{}, vs...)
+		for i := range vs {
+			vs[i] = strings.ToLower(vs[i])
+		}
+		sort.Strings(vs)
+		ks = append(ks, strings.Join(vs, "\x1f"))
+	}
+	return strings.Join(ks, "\x1e"), true
+}
+
+func (r LinkRule) contains(target, d Data) bool {
+	if !r.Within {
+		return true
+	}
+	var span time.Duration
+	if vs, _ := lookupField(target, FileDuration); len(vs) > 0 {
+		span, _ = ParseDurationISO(vs[0])
+	}
+	ends := target.AcqTime.Add(span)
+	return !d.AcqTime.Before(target.AcqTime) && !d.AcqTime.After(ends)
+}
+
+// Apply evaluates the rules against all the products of the archive. Only the
+// pairs of products involving at least one product stored during the current
+// run are related.
+func (g *graph) Apply(ctx context.Context, a Archive, rules []LinkRule) error {
+	if g == nil || len(rules) == 0 {
+		return nil
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	var (
+		fresh = make(map[string]bool)
+		paths []string
+		list  []Data
+	)
+	for _, p := range g.paths {
+		fresh[p] = true
+	}
+	if len(fresh) == 0 {
+		return nil
+	}
+	err := WalkMeta(ctx, a.MetaDir, func(rel string, d Data) error {
+		paths = append(paths, rel)
+		list = append(list, d)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for _, r := range rules {
+		targets := make(map[string][]int)
+		for i, d := range list {
+			if !r.to.Match(d) {
+				continue
+			}
+			if k, ok := r.key(d); ok {
+				targets[k] = append(targets[k], i)
+			}
+		}
+		for i, d := range list {
+			if !r.from.Match(d) {
+				continue
+			}
+			k, ok := r.key(d)
+			if !ok {
+				continue
+			}
+			for _, j := range targets[k] {
+				if i == j || (!fresh[paths[i]] && !fresh[paths[j]]) || !r.contains(list[j], d) {
+					continue
+				}
+				g.paths[paths[i]] = paths[i]
+				g.paths[paths[j]] = paths[j]
+				g.relations = append(g.relations, relation{from: paths[i], to: paths[j], role: r.Role})
+			}
+		}
+	}
+	return nil
+}
+
+// Flush updates the metadata files of the products involved in a relation so
+// that each of them references the other one. The metadata of the products
+// stored during the run (or the interrupted run it resumes) has already been
+// checked against the no-overwrite
+// option and reported in the difflog: it is rewritten with its reciprocal links
+// without being checked nor reported again.
+func (g *graph) Flush(a Archive) error {
+	if g == nil {
+		return nil
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	var (
+		links = make(map[string][]Link)
+		data  = make(map[string]Data)
+		load  = func(file string) (Data, bool) {
+			if d, ok := data[file]; ok {
+				return d, true
+			}
+			d, ok := g.stored[file]
+			if !ok {
+				x, err := DecodeFile(filepath.Join(a.MetaDir, file) + ".xml")
+				if err != nil {
+					return d, false
+				}
+				x.relativeRoot = strings.TrimSuffix(strings.TrimSuffix(x.File, file), "/")
+				d = x
+			}
+			d.File = file
+			data[file] = d
+			return d, true
+		}
+	)
+	for _, r := range g.relations {
+		from, ok := g.paths[r.from]
+		if !ok {
+			continue
+		}
+		// the target may be a product of a previous run
+		to, ok := g.paths[r.to]
+		if !ok {
+			to = r.to
+		}
+		if from == to {
+			continue
+		}
+		src, ok1 := load(from)
+		dst, ok2 := load(to)
+		if !ok1 || !ok2 {
+			continue
+		}
+		role := r.role
+		if role == "" {
+			role = dst.Type
+		}
+		links[from] = append(links[from], CreateLink(to, role))
+		links[to] = append(links[to], CreateLink(from, inverseRole(r.role, src.Type)))
+	}
+	files := make([]string, 0, len(links))
+	for f := range links {
+		files = append(files, f)
+	}
+	sort.Strings(files)
+
+	var (
+		es  Errors
+		run = a
+	)
+	run.NoOverwrite, run.DiffLog = false, ""
+	for _, f := range files {
+		d, ok := load(f)
+		if !ok {
+			continue
+		}
+		if !updateLinks(&d, links[f], g.paths) {
+			continue
+		}
+		w := a
+		if _, ok := g.stored[f]; ok || g.restored[f] {
+			w = run
+		}
+		if err := w.writeMeta(d, f); err != nil {
+			es = append(es, fmt.Errorf("%s: %w", f, err))
+		}
+	}
+	return es.Err()
+}
+
+// updateLinks adds to d the links not yet present, rewrites references to
+// stored products with their path in the archive and sets missing roles.
+func updateLinks(d *Data, links []Link, paths map[string]string) bool {
+	var (
+		changed bool
+		list    = d.Links[:0]
+	)
+	for _, k := range d.Links {
+		if p, ok := paths[k.File]; ok && p != k.File {
+			k.File = p
+			changed = true
+		}
+		if k.File == d.File {
+			changed = true
+			continue
+		}
+		list = append(list, k)
+	}
+	d.Links = list
+	for _, k := range links {
+		j := -1
+		for i := range d.Links {
+			if d.Links[i].File == k.File {
+				j = i
+				break
+			}
+		}
+		switch {
+		case j < 0:
+			d.Links = append(d.Links, k)
+			changed = true
+		case d.Links[j].Role == "" && k.Role != "":
+			d.Links[j].Role = k.Role
+			changed = true
+		}
+	}
+	return changed
+}
+
+func inverseRole(role, kind string) string {
+	switch role {
+	case RoleSupersedes:
+		return RoleSupersededBy
+	case RoleSupersededBy:
+		return RoleSupersedes
+	default:
+		return kind
+	}
+}
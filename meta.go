@@ -1,6 +1,8 @@
 package prospect
 
 import (
+	"bytes"
+	"context"
 	"crypto/md5"
 	"crypto/sha256"
 	"encoding/xml"
@@ -11,11 +13,20 @@ import (
 	"os"
 	"path/filepath"
 	"sort"
+	"strconv"
 	"strings"
 	"time"
 )
 
-var ErrIgnore = errors.New("ignore")
+var (
+	ErrIgnore     = errors.New("ignore")
+	ErrDuplicate  = errors.New("duplicate parameter")
+	ErrConfig     = errors.New("invalid configuration")
+	ErrAbort      = errors.New("processing aborted")
+	ErrPartial    = errors.New("processing partially failed")
+	ErrOverwrite  = errors.New("file already exists with different content")
+	ErrNotProduct = errors.New("not a product metadata")
+)
 
 const (
 	SHA = "SHA256"
@@ -42,10 +53,11 @@ const (
 )
 
 const (
-	ptrRef   = "ptr.%d.href"
-	ptrRole  = "ptr.%d.role"
-	fileSize = "file.size"
-	fileMD5  = "file.md5"
+	ptrRef      = "ptr.%d.href"
+	ptrRole     = "ptr.%d.role"
+	fileSize    = "file.size"
+	fileMD5     = "file.md5"
+	fileVersion = "file.version"
 
 	FileDuration = "file.duration"
 	FileRecord   = "file.numrec"
@@ -96,22 +108,6 @@ func (i Increment) Contains(t time.Time) bool {
 	return i.Starts.Before(t) && i.Ends.After(t)
 }
 
-type Parameter struct {
-	Name  string `xml:"name"`
-	Value string `xml:"value"`
-}
-
-func MakeParameter(k string, v interface{}) Parameter {
-	if d, ok := v.(time.Duration); ok {
-		v = FormatDurationISO(d)
-	}
-	p := Parameter{
-		Name:  k,
-		Value: fmt.Sprintf("%v", v),
-	}
-	return p
-}
-
 type Payload struct {
 	XMLName xml.Name `toml:"-" xml:"payload"`
 	Accr    string   `toml:"acronym" xml:"-"`
@@ -160,13 +156,29 @@ func (m Meta) MarshalXML(e *xml.Encoder, _ xml.StartElement) error {
 }
 
 type Link struct {
-	File string
-	Role string
+	File string `json:"file"`
+	Role string `json:"role,omitempty"`
 }
 
 func CreateLinkFrom(d Data) Link {
-	file := filepath.Join(d.Resolve(), filepath.Base(d.File))
-	return CreateLink(file, d.Type)
+	return CreateLink(d.Path(), d.Type)
+}
+
+// mergeLinks appends to links the links of others referencing files not
+// already referenced by links.
+func mergeLinks(links, others []Link) []Link {
+	seen := make(map[string]struct{})
+	for _, k := range links {
+		seen[k.File] = struct{}{}
+	}
+	ks := append([]Link{}, links...)
+	for _, k := range others {
+		if _, ok := seen[k.File]; !ok {
+			ks = append(ks, k)
+			seen[k.File] = struct{}{}
+		}
+	}
+	return ks
 }
 
 func CreateLink(n, r string) Link {
@@ -177,49 +189,200 @@ func CreateLink(n, r string) Link {
 }
 
 type Archive struct {
-	DataDir string `toml:"datadir"`
-	MetaDir string `toml:"metadir"`
+	DataDir     string `toml:"datadir"`
+	MetaDir     string `toml:"metadir"`
+	NoOverwrite bool   `toml:"no-overwrite"`
+	DiffLog     string `toml:"difflog"`
 }
 
 func (a Archive) CreateFile(d Data, buf []byte) (Link, error) {
-	var k Link
-	d.File = filepath.Join(d.Resolve(), filepath.Base(d.File))
-	if err := a.storeFile(d, buf); err != nil {
-		return k, err
+	_, file, err := a.createFile(d, buf)
+	if err != nil {
+		return Link{}, err
+	}
+	return CreateLink(file, ""), nil
+}
+
+func (a Archive) createFile(d Data, buf []byte) (Data, string, error) {
+	if d.err != nil {
+		return d, "", d.err
+	}
+	d, file, err := a.locate(d)
+	if err != nil {
+		return d, file, err
+	}
+	x := d
+	x.File = file
+	if err := a.storeFile(x, buf); err != nil {
+		return d, file, err
+	}
+	return a.storeMeta(d, file)
+}
+
+// CopyFile stores the content of file as the data file of d. The content is
+// streamed and d is expected to already have its size and checksums set.
+func (a Archive) CopyFile(d Data, file string) (Link, error) {
+	_, path, err := a.copyData(d, file)
+	if err != nil {
+		return Link{}, err
 	}
-	k.File = d.File
-	k.Role = ""
-	return k, a.storeMeta(d, d.File)
+	return CreateLink(path, ""), nil
+}
+
+func (a Archive) copyData(d Data, file string) (Data, string, error) {
+	if d.err != nil {
+		return d, "", d.err
+	}
+	d, path, err := a.locate(d)
+	if err != nil {
+		return d, path, err
+	}
+	x := d
+	x.File = path
+	if err := a.copyFile(x, file); err != nil {
+		return d, path, err
+	}
+	return a.storeMeta(d, path)
 }
 
 func (a Archive) Store(d Data) error {
-	file := filepath.Join(d.Resolve(), filepath.Base(d.File))
+	_, _, err := a.store(d)
+	return err
+}
+
+// store gives d with its version set as located in the archive and its path
+// in the archive.
+func (a Archive) store(d Data) (Data, string, error) {
+	if d.err != nil {
+		return d, "", d.err
+	}
+	d, file, err := a.locate(d)
+	if err != nil {
+		return d, file, err
+	}
 	if err := a.storeLink(d, file); err != nil {
-		return err
+		return d, file, err
 	}
 	return a.storeMeta(d, file)
 }
 
-func (a Archive) storeMeta(d Data, file string) error {
+// locate gives the path of d in the archive. For versioned products, the
+// version is incremented (auto-version) until a free path or a path holding the
+// same content is found. With an explicit version, a path holding a different
+// content is an error.
+func (a Archive) locate(d Data) (Data, string, error) {
+	if d.Version <= 0 && !d.AutoVersion {
+		return d, d.Path(), nil
+	}
+	if d.Version <= 0 {
+		d.Version = 1
+	}
+	for {
+		file := d.Path()
+		x, err := DecodeFile(filepath.Join(a.MetaDir, file) + ".xml")
+		if errors.Is(err, os.ErrNotExist) || (err == nil && (x.Sum == d.Sum || d.Sum == "")) {
+			return d, file, nil
+		}
+		if err != nil {
+			return d, file, err
+		}
+		if !d.AutoVersion {
+			return d, file, fmt.Errorf("%w: %s (version %d)", ErrOverwrite, file, d.Version)
+		}
+		d.Version++
+	}
+}
+
+// storeMeta writes the metadata of d keeping the links of the metadata file
+// already present in the archive. It gives d with these links and its path in
+// the archive.
+func (a Archive) storeMeta(d Data, file string) (Data, string, error) {
+	if x, err := DecodeFile(filepath.Join(a.MetaDir, file) + ".xml"); err == nil {
+		d.Links = mergeLinks(d.Links, x.Links)
+	}
+	return d, file, a.writeMeta(d, file)
+}
+
+func (a Archive) writeMeta(d Data, file string) error {
 	d.File = file
-	file = filepath.Join(a.MetaDir, file)
-	if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+	file = filepath.Join(a.MetaDir, file) + ".xml"
+
+	var buf bytes.Buffer
+	if err := EncodeData(&buf, d); err != nil {
 		return err
 	}
-	w, err := os.Create(file + ".xml")
-	if err != nil {
+	old, err := a.compare(file, buf.Bytes())
+	if err != nil || old == nil {
 		return err
 	}
-	defer w.Close()
-	return EncodeData(w, d)
+	if len(old) > 0 {
+		if err := a.logDiff(file, old, buf.Bytes()); err != nil {
+			return err
+		}
+	}
+	return a.write(file, buf.Bytes())
 }
 
 func (a Archive) storeFile(d Data, buf []byte) error {
 	file := filepath.Join(a.DataDir, d.File)
-	if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+	old, err := a.compare(file, buf)
+	if err != nil || old == nil {
 		return err
 	}
-	return ioutil.WriteFile(file, buf, 0644)
+	return a.write(file, buf)
+}
+
+func (a Archive) copyFile(d Data, src string) error {
+	file := filepath.Join(a.DataDir, d.File)
+	if r, err := os.Open(file); err == nil {
+		var x Data
+		err = ReadFrom(&x, r)
+		r.Close()
+		switch {
+		case err != nil:
+			return err
+		case x.Sum == d.Sum:
+			return nil
+		case a.NoOverwrite:
+			return fmt.Errorf("%w: %s", ErrOverwrite, file)
+		}
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	r, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	return writeFile(file, func(w io.Writer) error {
+		_, err := io.Copy(w, r)
+		return err
+	})
+}
+
+func (a Archive) write(file string, buf []byte) error {
+	return writeFile(file, func(w io.Writer) error {
+		_, err := w.Write(buf)
+		return err
+	})
+}
+
+// compare returns nil when file already exists with the same content, an
+// empty slice when file does not exist and the current content otherwise.
+func (a Archive) compare(file string, buf []byte) ([]byte, error) {
+	old, err := ioutil.ReadFile(file)
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+		return []byte{}, nil
+	case err != nil:
+		return nil, err
+	case bytes.Equal(old, buf):
+		return nil, nil
+	case a.NoOverwrite:
+		return nil, fmt.Errorf("%w: %s", ErrOverwrite, file)
+	default:
+		return old, nil
+	}
 }
 
 func (a Archive) storeLink(d Data, file string) error {
@@ -227,20 +390,61 @@ func (a Archive) storeLink(d Data, file string) error {
 	if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
 		return err
 	}
-	var err error
+	link := os.Link
 	switch strings.ToLower(d.Link) {
 	case "hard", "":
-		err = os.Link(d.File, file)
 	case "soft", "sym", "symbolic":
-		err = os.Symlink(d.File, file)
+		link = os.Symlink
 	// case "copy":
 	default:
 		return fmt.Errorf("%s: unsupported link type", d.Link)
 	}
-	if errors.Is(err, os.ErrExist) {
-		err = nil
+	err := link(d.File, file)
+	if !errors.Is(err, os.ErrExist) {
+		return err
 	}
-	return err
+	same, err := sameContent(d, file)
+	if err != nil || same {
+		return err
+	}
+	if a.NoOverwrite {
+		return fmt.Errorf("%w: %s", ErrOverwrite, file)
+	}
+	tmp := filepath.Join(filepath.Dir(file), "."+filepath.Base(file)+".link")
+	os.Remove(tmp)
+	if err := link(d.File, tmp); err != nil {
+		return err
+	}
+	return os.Rename(tmp, file)
+}
+
+// sameContent tells if file, already present in the archive, is the data file
+// of d or has the same content.
+func sameContent(d Data, file string) (bool, error) {
+	i, err := os.Stat(file)
+	if errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if j, err := os.Stat(d.File); err == nil && os.SameFile(i, j) {
+		return true, nil
+	}
+	if d.Sum == "" {
+		return true, nil
+	}
+	r, err := OpenFile(file)
+	if err != nil {
+		return false, err
+	}
+	defer r.Close()
+
+	var x Data
+	if err := ReadFrom(&x, r); err != nil {
+		return false, err
+	}
+	return x.Sum == d.Sum, nil
 }
 
 type Context struct {
@@ -256,6 +460,8 @@ type Context struct {
 	Metadata   []Parameter
 
 	RelativeRoot string `toml:"relative-root"`
+	Duplicate    string
+	Namespace    string
 }
 
 func (c Context) Update(d Data) Data {
@@ -271,8 +477,14 @@ func (c Context) Update(d Data) Data {
 	if d.Owner == "" {
 		d.Owner = c.Owner
 	}
-	d.Parameters = append(d.Parameters, c.Metadata...)
+	for _, p := range c.Metadata {
+		if d.indexOf(p.Name) < 0 {
+			d.Parameters = append(d.Parameters, p)
+		}
+	}
 	d.relativeRoot = c.RelativeRoot
+	d.policy = c.Duplicate
+	d.namespace = c.Namespace
 	return c.update(d)
 }
 
@@ -305,16 +517,27 @@ type Data struct {
 	AcqTime    time.Time
 	Archive    Pattern
 
+	Version     int
+	AutoVersion bool `toml:"auto-version"`
+
 	Mimes    MimeSet `toml:"mimetype"`
 	TimeFunc `toml:"timefunc"`
 	Link     string
+	Sniff    bool
 
 	Parameters []Parameter `toml:"metadata"`
 	Links      []Link      `toml:"links"`
+	Sidecars   []Sidecar   `toml:"sidecar"`
+	Derivers   []Deriver   `toml:"derive"`
+	Commands   []string    `toml:"commands"`
 
 	Size         int64
 	MD5          string
 	relativeRoot string
+	policy       string
+	namespace    string
+	err          error
+	warnings     []error
 }
 
 func ReadFile(d *Data, file string) error {
@@ -337,6 +560,16 @@ func ReadFile(d *Data, file string) error {
 	if err = ReadFrom(d, r); err != nil {
 		return err
 	}
+	if d.Sniff {
+		if err := sniffFile(d, file); err != nil {
+			return err
+		}
+	}
+	for _, s := range d.Sidecars {
+		if err := s.Update(d); err != nil {
+			return err
+		}
+	}
 	if d.AcqTime.IsZero() {
 		when, err := d.TimeFunc.GetTime(file)
 		if err == nil {
@@ -345,7 +578,31 @@ func ReadFile(d *Data, file string) error {
 		}
 	}
 	if filepath.Ext(file) == ExtGZ {
-		d.Register(FileEncoding, MimeGz)
+		d.set(MakeParameter(FileEncoding, MimeGz))
+	}
+	return nil
+}
+
+func sniffFile(d *Data, file string) error {
+	r, err := OpenFile(file)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	m, err := Sniff(r)
+	if err != nil || m.isZero() {
+		return err
+	}
+	if d.Mime == "" {
+		d.Mime = m.Mime
+		if d.Type == "" {
+			d.Type = m.Type
+		}
+		return nil
+	}
+	if !sameMime(m.Mime, d.Mime) {
+		d.set(MakeParameter(FileMismatch, m.Mime))
 	}
 	return nil
 }
@@ -378,6 +635,12 @@ func (d Data) Clone() Data {
 	return x
 }
 
+// Warnings gives the errors that did not prevent d from being stored, such as
+// the failures of the commands whose exit policy is skip.
+func (d Data) Warnings() []error {
+	return d.warnings
+}
+
 func (d *Data) ClearLinks() {
 	if len(d.Links) > 0 {
 		d.Links = d.Links[:0]
@@ -394,8 +657,49 @@ func (d *Data) Register(name string, value interface{}) {
 	if name == "" || value == nil {
 		return
 	}
-	p := MakeParameter(name, value)
-	d.Parameters = append(d.Parameters, p)
+	d.set(MakeParameter(d.prefix(name), value))
+}
+
+func (d *Data) RegisterWithUnit(name string, value interface{}, unit string) {
+	if name == "" || value == nil {
+		return
+	}
+	d.set(MakeParameterWithUnit(d.prefix(name), value, unit))
+}
+
+func (d *Data) set(p Parameter) {
+	x := d.indexOf(p.Name)
+	if x < 0 {
+		d.Parameters = append(d.Parameters, p)
+		return
+	}
+	switch strings.ToLower(d.policy) {
+	case PolicyKeep:
+	case PolicyError:
+		if d.err == nil {
+			d.err = fmt.Errorf("%w: %s", ErrDuplicate, p.Name)
+		}
+	default:
+		d.Parameters[x] = p
+	}
+}
+
+func (d *Data) indexOf(name string) int {
+	for i := range d.Parameters {
+		if strings.EqualFold(d.Parameters[i].Name, name) {
+			return i
+		}
+	}
+	return -1
+}
+
+// prefix adds the namespace to name unless name is one of the default
+// metadata (file.*).
+func (d *Data) prefix(name string) string {
+	if d.namespace == "" || strings.HasPrefix(strings.ToLower(name), "file.") {
+		return name
+	}
+	return d.namespace + "." + name
 }
 
 func (d Data) Resolve() string {
@@ -405,6 +709,17 @@ func (d Data) Resolve() string {
 	return d.Archive.Resolve(d)
 }
 
+// Path gives the path of d in the archive. When d has a version greater than
+// 1 and its archive pattern has no version placeholder, the version is added as
+// a suffix to the name of the file.
+func (d Data) Path() string {
+	base := filepath.Base(d.File)
+	if d.Version > 1 && !hasPlaceholder(d.Archive.Resolver, levelVersion) {
+		base = VersionPath(base, d.Version)
+	}
+	return filepath.Join(d.Resolve(), base)
+}
+
 func (d Data) Accept(file string) bool {
 	if len(d.Extensions) == 0 {
 		return false
@@ -453,20 +768,24 @@ func (d Data) MarshalXML(e *xml.Encoder, s xml.StartElement) error {
 		Value:  d.Sum,
 	}
 	e.EncodeElement(xs, startElement("integrity"))
+	d.Parameters = append([]Parameter{}, d.Parameters...)
+	d.policy = ""
 	for i, k := range d.Links {
-		h := MakeParameter(fmt.Sprintf(ptrRef, i+1), k.File)
-		d.Parameters = append(d.Parameters, h)
+		d.set(MakeParameter(fmt.Sprintf(ptrRef, i+1), k.File))
 		if k.Role != "" {
-			r := MakeParameter(fmt.Sprintf(ptrRole, i+1), k.Role)
-			d.Parameters = append(d.Parameters, r)
+			d.set(MakeParameter(fmt.Sprintf(ptrRole, i+1), k.Role))
 		}
 	}
 	if d.Size > 0 {
-		d.Parameters = append(d.Parameters, MakeParameter(fileSize, d.Size))
+		d.set(MakeParameterWithUnit(fileSize, d.Size, UnitByte))
 	}
 	if d.MD5 != "" {
-		d.Parameters = append(d.Parameters, MakeParameter(fileMD5, d.MD5))
+		d.set(MakeParameter(fileMD5, d.MD5))
 	}
+	if d.Version > 0 {
+		d.set(MakeParameter(fileVersion, d.Version))
+	}
+	sortParameters(d.Parameters)
 	ps := struct {
 		Values []Parameter `xml:"parameter"`
 	}{
@@ -477,6 +796,135 @@ func (d Data) MarshalXML(e *xml.Encoder, s xml.StartElement) error {
 	return nil
 }
 
+func (d *Data) UnmarshalXML(dec *xml.Decoder, s xml.StartElement) error {
+	var doc struct {
+		Experiment string      `xml:"experimentName"`
+		Model      string      `xml:"model"`
+		Source     string      `xml:"dataSource"`
+		Owner      string      `xml:"dataOwner"`
+		AcqTime    string      `xml:"acquisitionTime"`
+		ModTime    string      `xml:"creationTime"`
+		Increments []string    `xml:"increments>increment"`
+		Crews      []string    `xml:"involvedCrew>crewMemberName"`
+		Level      int         `xml:"processingLevel"`
+		Type       string      `xml:"productType"`
+		Mime       string      `xml:"fileFormat"`
+		File       string      `xml:"relativePath"`
+		Integrity  string      `xml:"integrity>method"`
+		Sum        string      `xml:"integrity>value"`
+		Parameters []Parameter `xml:"experimentSpecificMetadata>parameter"`
+	}
+	if err := dec.DecodeElement(&doc, &s); err != nil {
+		return err
+	}
+	d.Experiment = doc.Experiment
+	d.Model = doc.Model
+	d.Source = doc.Source
+	d.Owner = doc.Owner
+	d.Increments = doc.Increments
+	d.Crews = doc.Crews
+	d.Level = doc.Level
+	d.Type = doc.Type
+	d.Mime = doc.Mime
+	d.File = doc.File
+	d.Integrity = doc.Integrity
+	d.Sum = doc.Sum
+	d.AcqTime, _ = time.Parse(time.RFC3339, doc.AcqTime)
+	d.ModTime, _ = time.Parse(time.RFC3339, doc.ModTime)
+
+	var (
+		refs  = make(map[int]string)
+		roles = make(map[int]string)
+	)
+	d.Parameters = d.Parameters[:0]
+	for _, p := range doc.Parameters {
+		var ix int
+		switch {
+		case p.Name == fileSize:
+			d.Size, _ = strconv.ParseInt(p.Value, 10, 64)
+		case p.Name == fileMD5:
+			d.MD5 = p.Value
+		case p.Name == fileVersion:
+			d.Version, _ = strconv.Atoi(p.Value)
+		case scanPointer(p.Name, ptrRef, &ix):
+			refs[ix] = p.Value
+		case scanPointer(p.Name, ptrRole, &ix):
+			roles[ix] = p.Value
+		default:
+			d.Parameters = append(d.Parameters, p)
+		}
+	}
+	ix := make([]int, 0, len(refs))
+	for i := range refs {
+		ix = append(ix, i)
+	}
+	sort.Ints(ix)
+	d.Links = d.Links[:0]
+	for _, i := range ix {
+		d.Links = append(d.Links, CreateLink(refs[i], roles[i]))
+	}
+	return nil
+}
+
+func scanPointer(name, pattern string, ix *int) bool {
+	n, err := fmt.Sscanf(name, pattern, ix)
+	return err == nil && n == 1 && fmt.Sprintf(pattern, *ix) == name
+}
+
+func DecodeData(r io.Reader) (Data, error) {
+	var (
+		d   Data
+		dec = xml.NewDecoder(r)
+	)
+	for {
+		tok, err := dec.Token()
+		if err != nil {
+			return d, err
+		}
+		s, ok := tok.(xml.StartElement)
+		if !ok {
+			continue
+		}
+		if s.Name.Local != "metadata" {
+			return d, fmt.Errorf("%w: %s", ErrNotProduct, s.Name.Local)
+		}
+		return d, dec.DecodeElement(&d, &s)
+	}
+}
+
+func DecodeFile(file string) (Data, error) {
+	r, err := os.Open(file)
+	if err != nil {
+		return Data{}, err
+	}
+	defer r.Close()
+	return DecodeData(r)
+}
+
+// WalkMeta decodes each metadata file found in dir and gives it to fn with
+// its path relative to dir (without the .xml extension), the same path as
+// the one of the data file relative to the data directory. XML files that are
+// not metadata of products are skipped.
+func WalkMeta(ctx context.Context, dir string, fn func(string, Data) error) error {
+	return Walk(ctx, dir, func(file string, i os.FileInfo, err error) error {
+		if err != nil || i.IsDir() || filepath.Ext(file) != ".xml" {
+			return err
+		}
+		d, err := DecodeFile(file)
+		if errors.Is(err, ErrNotProduct) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %w", file, err)
+		}
+		rel, err := filepath.Rel(dir, file)
+		if err != nil {
+			return err
+		}
+		return fn(strings.TrimSuffix(rel, ".xml"), d)
+	})
+}
+
 func EncodeMeta(w io.Writer, m Meta) error {
 	doc := struct {
 		XMLName  xml.Name `xml:"http://eusoc.upm.es/SDC/Experiments/1 experiment"`
@@ -0,0 +1,170 @@
+package prospect
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	TypeInt      = "int"
+	TypeFloat    = "float"
+	TypeBool     = "bool"
+	TypeDatetime = "datetime"
+	TypeDuration = "duration"
+	TypeString   = "string"
+)
+
+const UnitByte = "byte"
+
+// DefaultPrecision is the number of decimals kept for the values of type float
+// when no precision is given.
+const DefaultPrecision = 6
+
+const (
+	PolicyOverride = "override"
+	PolicyKeep     = "keep-first"
+	PolicyError    = "error"
+)
+
+type Parameter struct {
+	Name      string `xml:"name" json:"name"`
+	Value     string `xml:"value" json:"value"`
+	Type      string `xml:"type,omitempty" json:"type,omitempty"`
+	Unit      string `xml:"unit,omitempty" json:"unit,omitempty"`
+	Precision int    `xml:"-" json:"-"`
+}
+
+func MakeParameter(k string, v interface{}) Parameter {
+	p := Parameter{
+		Name: k,
+	}
+	switch v := v.(type) {
+	case time.Duration:
+		p.Type, p.Value = TypeDuration, FormatDurationISO(v)
+	case time.Time:
+		p.Type, p.Value = TypeDatetime, v.UTC().Format(time.RFC3339)
+	case bool:
+		p.Type, p.Value = TypeBool, strconv.FormatBool(v)
+	case float32:
+		p.Type, p.Value = TypeFloat, strconv.FormatFloat(float64(v), 'f', DefaultPrecision, 32)
+	case float64:
+		p.Type, p.Value = TypeFloat, strconv.FormatFloat(v, 'f', DefaultPrecision, 64)
+	case int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64:
+		p.Type, p.Value = TypeInt, fmt.Sprintf("%d", v)
+	default:
+		p.Value = fmt.Sprintf("%v", v)
+	}
+	return p
+}
+
+func MakeParameterWithUnit(k string, v interface{}, unit string) Parameter {
+	p := MakeParameter(k, v)
+	p.Unit = unit
+	return p
+}
+
+func (p Parameter) normalize() (Parameter, error) {
+	var err error
+	switch strings.ToLower(p.Type) {
+	case "", TypeString:
+	case TypeInt:
+		var n int64
+		if n, err = strconv.ParseInt(p.Value, 0, 64); err == nil {
+			p.Value = strconv.FormatInt(n, 10)
+		}
+	case TypeFloat:
+		var f float64
+		if f, err = strconv.ParseFloat(p.Value, 64); err == nil {
+			prec := p.Precision
+			if prec <= 0 {
+				prec = DefaultPrecision
+			}
+			p.Value = strconv.FormatFloat(f, 'f', prec, 64)
+		}
+	case TypeBool:
+		var b bool
+		if b, err = strconv.ParseBool(p.Value); err == nil {
+			p.Value = strconv.FormatBool(b)
+		}
+	case TypeDatetime:
+		var w time.Time
+		if w, err = parseTime(p.Value); err == nil {
+			p.Value = w.UTC().Format(time.RFC3339)
+		}
+	case TypeDuration:
+		if strings.HasPrefix(p.Value, "P") {
+			_, err = ParseDurationISO(p.Value)
+			break
+		}
+		var d time.Duration
+		if d, err = time.ParseDuration(p.Value); err == nil {
+			p.Value = FormatDurationISO(d)
+		}
+	default:
+		err = fmt.Errorf("unknown type")
+	}
+	if err != nil {
+		err = fmt.Errorf("%s(%s): %s: %w", p.Name, p.Type, p.Value, err)
+	}
+	p.Type = strings.ToLower(p.Type)
+	return p, err
+}
+
+func normalizeParameters(ps []Parameter) error {
+	for i := range ps {
+		p, err := ps[i].normalize()
+		if err != nil {
+			return err
+		}
+		ps[i] = p
+	}
+	return nil
+}
+
+var timePatterns = []string{
+	time.RFC3339Nano,
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04:05",
+	"2006-01-02",
+}
+
+func parseTime(str string) (time.Time, error) {
+	var (
+		when time.Time
+		err  error
+	)
+	for _, p := range timePatterns {
+		if when, err = time.Parse(p, str); err == nil {
+			break
+		}
+	}
+	return when, err
+}
+
+func sortParameters(ps []Parameter) {
+	sort.SliceStable(ps, func(i, j int) bool {
+		return lessName(ps[i].Name, ps[j].Name)
+	})
+}
+
+func lessName(left, right string) bool {
+	var (
+		ls = strings.Split(left, ".")
+		rs = strings.Split(right, ".")
+	)
+	for i := 0; i < len(ls) && i < len(rs); i++ {
+		if ls[i] == rs[i] {
+			continue
+		}
+		x, err1 := strconv.Atoi(ls[i])
+		y, err2 := strconv.Atoi(rs[i])
+		if err1 == nil && err2 == nil {
+			return x < y
+		}
+		return ls[i] < rs[i]
+	}
+	return len(ls) < len(rs)
+}
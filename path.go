@@ -67,6 +67,7 @@ const (
 	levelSecLong  = "second"
 	levelSecShort = "sec"
 	levelStamp    = "timestamp"
+	levelVersion  = "version"
 )
 
 func parse(str string) (Resolver, error) {
@@ -287,6 +288,12 @@ func (f fragment) Resolve(dat Data) string {
 		str = fmt.Sprintf("%02d", dat.AcqTime.Second())
 	case levelStamp:
 		str = strconv.Itoa(int(dat.AcqTime.Unix()))
+	case levelVersion:
+		v := dat.Version
+		if v <= 0 {
+			v = 1
+		}
+		str = strconv.Itoa(v)
 	}
 	return str
 }
@@ -324,3 +331,23 @@ func splitMime(mime string) string {
 	}
 	return mime
 }
+
+func hasPlaceholder(r Resolver, name string) bool {
+	switch r := r.(type) {
+	case fragment:
+		return strings.ToLower(r.name) == name
+	case path:
+		for _, x := range r.rs {
+			if hasPlaceholder(x, name) {
+				return true
+			}
+		}
+	case compound:
+		for _, x := range r.rs {
+			if hasPlaceholder(x, name) {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,242 @@
+package prospect
+
+import (
+	"bufio"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/midbel/toml"
+)
+
+const (
+	FormatXML  = "xml"
+	FormatJSON = "json"
+	FormatTOML = "toml"
+	FormatKV   = "kv"
+)
+
+const (
+	fieldAcqTime = "acqtime"
+	fieldModTime = "modtime"
+)
+
+type Field struct {
+	Path   string
+	Name   string
+	Time   string
+	Layout string
+}
+
+type Sidecar struct {
+	File   string
+	Format string
+	Fields []Field `toml:"field"`
+}
+
+func (s Sidecar) Update(d *Data) error {
+	file := expand(s.File, *d)
+	if !filepath.IsAbs(file) {
+		file = filepath.Join(filepath.Dir(d.File), file)
+	}
+	r, err := os.Open(file)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			err = nil
+		}
+		return err
+	}
+	defer r.Close()
+
+	get, err := s.decode(r, file)
+	if err != nil {
+		return fmt.Errorf("%s: %w", file, err)
+	}
+	for _, f := range s.Fields {
+		v, ok := get(strings.Split(strings.Trim(f.Path, "/"), "/"))
+		if !ok {
+			continue
+		}
+		if f.Name != "" {
+			d.Register(f.Name, v)
+		}
+		if f.Time == "" {
+			continue
+		}
+		when, err := f.parseTime(v)
+		if err != nil {
+			return fmt.Errorf("%s: %s: %w", file, f.Path, err)
+		}
+		switch strings.ToLower(f.Time) {
+		case fieldAcqTime:
+			d.AcqTime = when
+		case fieldModTime:
+			d.ModTime = when
+		default:
+			return fmt.Errorf("%s: unknown time field", f.Time)
+		}
+	}
+	return nil
+}
+
+func (s Sidecar) decode(r io.Reader, file string) (func([]string) (interface{}, bool), error) {
+	format := strings.ToLower(s.Format)
+	if format == "" {
+		format = strings.TrimPrefix(filepath.Ext(file), ".")
+	}
+	switch format {
+	case FormatXML:
+		var n node
+		if err := xml.NewDecoder(r).Decode(&n); err != nil {
+			return nil, err
+		}
+		return n.lookup, nil
+	case FormatJSON:
+		var (
+			v  interface{}
+			rs = json.NewDecoder(r)
+		)
+		rs.UseNumber()
+		if err := rs.Decode(&v); err != nil {
+			return nil, err
+		}
+		return lookupValue(v), nil
+	case FormatTOML:
+		v := make(map[string]interface{})
+		if err := toml.Decode(r, &v); err != nil {
+			return nil, err
+		}
+		return lookupValue(v), nil
+	case FormatKV, "txt", "ini", "properties":
+		v, err := readPairs(r)
+		if err != nil {
+			return nil, err
+		}
+		return func(p []string) (interface{}, bool) {
+			x, ok := v[strings.Join(p, "/")]
+			return x, ok
+		}, nil
+	default:
+		return nil, fmt.Errorf("%s: unsupported sidecar format", format)
+	}
+}
+
+func (f Field) parseTime(v interface{}) (time.Time, error) {
+	if w, ok := v.(time.Time); ok {
+		return w, nil
+	}
+	layout := f.Layout
+	if layout == "" {
+		layout = time.RFC3339
+	}
+	return time.Parse(layout, fmt.Sprintf("%v", v))
+}
+
+type node struct {
+	XMLName xml.Name
+	Attrs   []xml.Attr `xml:",any,attr"`
+	Text    string     `xml:",chardata"`
+	Nodes   []node     `xml:",any"`
+}
+
+func (n node) lookup(path []string) (interface{}, bool) {
+	if len(path) == 0 || (len(path) == 1 && path[0] == "") {
+		return strings.TrimSpace(n.Text), true
+	}
+	if name := path[0]; strings.HasPrefix(name, "@") {
+		for _, a := range n.Attrs {
+			if a.Name.Local == name[1:] && len(path) == 1 {
+				return a.Value, true
+			}
+		}
+		return nil, false
+	}
+	for _, c := range n.Nodes {
+		if c.XMLName.Local == path[0] {
+			return c.lookup(path[1:])
+		}
+	}
+	return nil, false
+}
+
+func lookupValue(root interface{}) func([]string) (interface{}, bool) {
+	return func(path []string) (interface{}, bool) {
+		v := root
+		for _, p := range path {
+			switch x := v.(type) {
+			case map[string]interface{}:
+				n, ok := x[p]
+				if !ok {
+					return nil, false
+				}
+				v = n
+			case []interface{}:
+				i, err := strconv.Atoi(p)
+				if err != nil || i < 0 || i >= len(x) {
+					return nil, false
+				}
+				v = x[i]
+			default:
+				return nil, false
+			}
+		}
+		switch v.(type) {
+		case map[string]interface{}, []interface{}:
+			return nil, false
+		default:
+			return v, v != nil
+		}
+	}
+}
+
+func readPairs(r io.Reader) (map[string]string, error) {
+	var (
+		vs   = make(map[string]string)
+		scan = bufio.NewScanner(r)
+	)
+	for scan.Scan() {
+		line := strings.TrimSpace(scan.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		x := strings.IndexAny(line, "=:")
+		if x < 0 {
+			continue
+		}
+		vs[strings.TrimSpace(line[:x])] = strings.Trim(strings.TrimSpace(line[x+1:]), "\"")
+	}
+	return vs, scan.Err()
+}
+
+const (
+	varFile = "{file}"
+	varBase = "{base}"
+	varStem = "{stem}"
+	varDir  = "{dir}"
+	varExt  = "{ext}"
+
+	varAcqTime = "{acqtime}"
+)
+
+func expand(str string, d Data) string {
+	var (
+		base = filepath.Base(d.File)
+		ext  = filepath.Ext(d.File)
+	)
+	r := strings.NewReplacer(
+		varFile, d.File,
+		varBase, base,
+		varStem, strings.TrimSuffix(base, ext),
+		varDir, filepath.Dir(d.File),
+		varExt, ext,
+		varAcqTime, d.AcqTime.UTC().Format(time.RFC3339),
+	)
+	return r.Replace(str)
+}
@@ -0,0 +1,236 @@
+package prospect
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"io"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/midbel/mime"
+)
+
+const (
+	MimePdf  = "application/pdf"
+	MimeTiff = "image/tiff"
+	MimeNef  = "image/x-nikon-nef"
+	MimeRT   = "application/octet-stream;access=sequential,form=unformatted"
+
+	MimeHdkImage   = "application/octet-stream;type=hpkt-vmu2,subtype=image"
+	MimeHdkScience = "application/octet-stream;type=hpkt-vmu2,subtype=science"
+
+	TypeDocument = "document"
+)
+
+const FileMismatch = "file.mismatch"
+
+const sniffLen = 512
+
+var (
+	magicPng  = []byte("\x89PNG\r\n\x1a\n")
+	magicJpeg = []byte("\xff\xd8\xff")
+	magicPdf  = []byte("%PDF-")
+	magicGz   = []byte("\x1f\x8b")
+	magicTiff = [][]byte{
+		[]byte("II*\x00"),
+		[]byte("MM\x00*"),
+	}
+	magicNikon = []byte("NIKON")
+	magicQuick = [][]byte{
+		[]byte("ftyp"),
+		[]byte("moov"),
+		[]byte("mdat"),
+		[]byte("wide"),
+		[]byte("free"),
+		[]byte("skip"),
+	}
+	hdkImages = [][]byte{
+		[]byte("Y800"),
+		[]byte("Y16 "),
+		[]byte("Y16L"),
+		[]byte("I420"),
+		[]byte("YUY2"),
+		[]byte("RGB "),
+		[]byte("JPEG"),
+		[]byte("PNG "),
+		[]byte("H264"),
+		[]byte("TIFF"),
+	}
+	hdkSciences = [][]byte{
+		[]byte("MMA "),
+		[]byte("CORR"),
+		[]byte("SYNC"),
+		[]byte("RAW "),
+		[]byte("SVS "),
+	}
+)
+
+func Sniff(r io.Reader) (Mime, error) {
+	buf := make([]byte, sniffLen)
+	n, err := io.ReadFull(r, buf)
+	if err != nil && n == 0 {
+		if err == io.EOF {
+			err = nil
+		}
+		return Mime{}, err
+	}
+	return DetectMime(buf[:n]), nil
+}
+
+func DetectMime(buf []byte) Mime {
+	var m Mime
+	switch {
+	case bytes.HasPrefix(buf, magicPng):
+		m.Mime, m.Type = MimePng, TypeImage
+	case bytes.HasPrefix(buf, magicJpeg):
+		m.Mime, m.Type = MimeJpeg, TypeImage
+	case bytes.HasPrefix(buf, magicPdf):
+		m.Mime, m.Type = MimePdf, TypeDocument
+	case bytes.HasPrefix(buf, magicGz):
+		m.Mime = MimeGz
+	case hasPrefix(buf, magicTiff):
+		m.Mime, m.Type = MimeTiff, TypeImage
+		if bytes.Contains(buf, magicNikon) {
+			m.Mime = MimeNef
+		}
+	case len(buf) >= 8 && hasPrefix(buf[4:], magicQuick):
+		m.Mime, m.Type = MimeQuick, TypeVideo
+	case hasPrefix(buf, hdkImages):
+		m.Mime, m.Type = MimeHdkImage, TypeImage
+	case hasPrefix(buf, hdkSciences):
+		m.Mime, m.Type = MimeHdkScience, TypeData
+	case isText(buf):
+		m.Mime, m.Type = MimePlain, TypeText
+		if delim := sniffDelimiter(buf); delim != "" {
+			m.Mime = MimeCsv + ";delimiter=" + delim
+		}
+	case isRT(buf):
+		m.Mime, m.Type = MimeRT, TypeData
+	default:
+		m.Mime = MimeOctet
+	}
+	return m
+}
+
+func sameMime(detected, configured string) bool {
+	if detected == MimeOctet {
+		return true
+	}
+	dt, err := mime.Parse(detected)
+	if err != nil {
+		return true
+	}
+	ct, err := mime.Parse(configured)
+	if err != nil {
+		return false
+	}
+	if dt.MainType != ct.MainType || dt.SubType != ct.SubType {
+		if dt.MainType == "image" && dt.SubType == "tiff" {
+			return ct.MainType == dt.MainType && strings.HasPrefix(ct.SubType, "x-")
+		}
+		return dt.MainType == "text" && ct.MainType == "text"
+	}
+	for _, k := range []string{"type", "subtype"} {
+		dv, ok := dt.Params[k]
+		if !ok {
+			continue
+		}
+		if cv, ok := ct.Params[k]; ok && !strings.EqualFold(dv, cv) {
+			return false
+		}
+	}
+	return true
+}
+
+func hasPrefix(buf []byte, magics [][]byte) bool {
+	for _, m := range magics {
+		if bytes.HasPrefix(buf, m) {
+			return true
+		}
+	}
+	return false
+}
+
+func isText(buf []byte) bool {
+	buf = bytes.TrimPrefix(buf, []byte("\xef\xbb\xbf"))
+	if len(buf) == 0 {
+		return false
+	}
+	for len(buf) > 0 {
+		r, z := utf8.DecodeRune(buf)
+		if r == utf8.RuneError && z == 1 {
+			// a multi bytes character can be cut at the end of the sniffed data
+			return len(buf) < utf8.UTFMax
+		}
+		if r < ' ' && r != '\n' && r != '\r' && r != '\t' {
+			return false
+		}
+		buf = buf[z:]
+	}
+	return true
+}
+
+var delimiters = []struct {
+	Char byte
+	Name string
+}{
+	{Char: ',', Name: "comma"},
+	{Char: '\t', Name: "tab"},
+	{Char: ';', Name: "semicolon"},
+	{Char: '|', Name: "pipe"},
+}
+
+func sniffDelimiter(buf []byte) string {
+	var (
+		lines [][]byte
+		scan  = bufio.NewScanner(bytes.NewReader(buf))
+	)
+	for scan.Scan() {
+		lines = append(lines, scan.Bytes())
+	}
+	if !bytes.HasSuffix(buf, []byte("\n")) && len(lines) > 1 {
+		// discard the last line because it is probably incomplete
+		lines = lines[:len(lines)-1]
+	}
+	if len(lines) < 2 {
+		return ""
+	}
+	for _, d := range delimiters {
+		count := bytes.Count(lines[0], []byte{d.Char})
+		if count == 0 {
+			continue
+		}
+		same := true
+		for _, i := range lines[1:] {
+			if bytes.Count(i, []byte{d.Char}) != count {
+				same = false
+				break
+			}
+		}
+		if same {
+			return d.Name
+		}
+	}
+	return ""
+}
+
+// isRT tells if buf starts with at least two complete records, each prefixed by
+// its size. The last record can be truncated but its size should be valid.
+func isRT(buf []byte) bool {
+	const (
+		minRecord = 8
+		maxRecord = 8 << 20
+	)
+	var offset, count int
+	for offset+4 <= len(buf) {
+		size := int(binary.LittleEndian.Uint32(buf[offset:]))
+		if size < minRecord || size >= maxRecord {
+			return false
+		}
+		if offset += size + 4; offset <= len(buf) {
+			count++
+		}
+	}
+	return count >= 2
+}
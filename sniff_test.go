@@ -0,0 +1,70 @@
+package prospect
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+func TestDetectMime(t *testing.T) {
+	data := []struct {
+		Name string
+		Buf  []byte
+		Mime string
+		Type string
+	}{
+		{Name: "png", Buf: []byte("\x89PNG\r\n\x1a\n\x00\x00"), Mime: MimePng, Type: TypeImage},
+		{Name: "jpeg", Buf: []byte("\xff\xd8\xff\xe0\x00\x10JFIF"), Mime: MimeJpeg, Type: TypeImage},
+		{Name: "pdf", Buf: []byte("%PDF-1.4\n"), Mime: MimePdf, Type: TypeDocument},
+		{Name: "gzip", Buf: []byte("\x1f\x8b\x08\x00"), Mime: MimeGz},
+		{Name: "tiff", Buf: []byte("II*\x00\x08\x00\x00\x00"), Mime: MimeTiff, Type: TypeImage},
+		{Name: "nef", Buf: []byte("MM\x00*\x00\x00\x00\x08NIKON"), Mime: MimeNef, Type: TypeImage},
+		{Name: "quicktime", Buf: []byte("\x00\x00\x00\x14ftypqt  "), Mime: MimeQuick, Type: TypeVideo},
+		{Name: "hadock image", Buf: []byte("Y800\x00\x00\x00\x01"), Mime: MimeHdkImage, Type: TypeImage},
+		{Name: "hadock science", Buf: []byte("MMA \x00\x00\x00\x01"), Mime: MimeHdkScience, Type: TypeData},
+		{Name: "text", Buf: []byte("hello world\n"), Mime: MimePlain, Type: TypeText},
+		{Name: "csv", Buf: []byte("a,b,c\n1,2,3\n4,5,6\n"), Mime: MimeCsv + ";delimiter=comma", Type: TypeText},
+		{Name: "rt", Buf: makeRecords(16, 32, 8), Mime: MimeRT, Type: TypeData},
+		{Name: "rt truncated", Buf: makeRecords(16, 32, 64)[:70], Mime: MimeRT, Type: TypeData},
+		{Name: "rt single record", Buf: makeRecords(16), Mime: MimeOctet},
+		{Name: "rt single record truncated", Buf: makeRecords(16, 64)[:30], Mime: MimeOctet},
+		{Name: "binary", Buf: []byte{0x20, 0, 0, 0, 0xff, 0xfe, 0, 1}, Mime: MimeOctet},
+		{Name: "invalid size", Buf: []byte{0x02, 0, 0, 0, 0xff, 0xfe, 0, 1, 0, 0, 0, 0}, Mime: MimeOctet},
+	}
+	for _, d := range data {
+		m := DetectMime(d.Buf)
+		if m.Mime != d.Mime || m.Type != d.Type {
+			t.Errorf("%s: want %s (%s), got %s (%s)", d.Name, d.Mime, d.Type, m.Mime, m.Type)
+		}
+	}
+}
+
+func TestSameMime(t *testing.T) {
+	data := []struct {
+		Detected   string
+		Configured string
+		Want       bool
+	}{
+		{Detected: MimePng, Configured: MimePng, Want: true},
+		{Detected: MimePng, Configured: MimeJpeg, Want: false},
+		{Detected: MimeOctet, Configured: MimeJpeg, Want: true},
+		{Detected: MimePlain, Configured: "text/x-log", Want: true},
+		{Detected: MimeTiff, Configured: "image/x-sony-arw", Want: true},
+		{Detected: MimeHdkImage, Configured: "application/octet-stream;type=hpkt-vmu2,subtype=image", Want: true},
+		{Detected: MimeHdkImage, Configured: "application/octet-stream;type=hpkt-vmu2,subtype=science", Want: false},
+	}
+	for _, d := range data {
+		if got := sameMime(d.Detected, d.Configured); got != d.Want {
+			t.Errorf("%s/%s: want %t, got %t", d.Detected, d.Configured, d.Want, got)
+		}
+	}
+}
+
+func makeRecords(sizes ...int) []byte {
+	var buf bytes.Buffer
+	for _, s := range sizes {
+		binary.Write(&buf, binary.LittleEndian, uint32(s))
+		buf.Write(make([]byte, s))
+	}
+	return buf.Bytes()
+}
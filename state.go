@@ -0,0 +1,175 @@
+package prospect
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// journal records the files processed by the runs. The relations of an
+// interrupted run are recorded too (lines starting with a tab) in order to be
+// flushed by the next run.
+type journal struct {
+	file *os.File
+	done map[string]struct{}
+
+	files     []string
+	relations []relation
+}
+
+func openJournal(file string) (*journal, error) {
+	if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(file, os.O_CREATE|os.O_RDWR|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	j := journal{
+		file: f,
+		done: make(map[string]struct{}),
+	}
+	scan := bufio.NewScanner(f)
+	for scan.Scan() {
+		line := scan.Text()
+		if line == "" {
+			continue
+		}
+		if !strings.HasPrefix(line, "\t") {
+			j.done[line] = struct{}{}
+			continue
+		}
+		switch fs := strings.Split(line[1:], "\t"); {
+		case fs[0] == "stored" && len(fs) == 2:
+			j.files = append(j.files, fs[1])
+		case fs[0] == "link" && len(fs) == 4:
+			j.relations = append(j.relations, relation{from: fs[1], to: fs[2], role: fs[3]})
+		case fs[0] == "flushed":
+			j.files, j.relations = nil, nil
+		}
+	}
+	if err := scan.Err(); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return &j, nil
+}
+
+func (j *journal) Has(file string) bool {
+	if j == nil {
+		return false
+	}
+	_, ok := j.done[file]
+	return ok
+}
+
+func (j *journal) Add(file string) error {
+	if j == nil || j.Has(file) {
+		return nil
+	}
+	if _, err := io.WriteString(j.file, file+"\n"); err != nil {
+		return err
+	}
+	j.done[file] = struct{}{}
+	return j.file.Sync()
+}
+
+// pending gives the products stored and the relations recorded by an
+// interrupted run.
+func (j *journal) pending() ([]string, []relation) {
+	if j == nil {
+		return nil, nil
+	}
+	return j.files, j.relations
+}
+
+// Save records the products stored and the relations of an interrupted run.
+func (j *journal) Save(files []string, rs []relation) error {
+	if j == nil || (len(files) == 0 && len(rs) == 0) {
+		return nil
+	}
+	var str strings.Builder
+	for _, f := range files {
+		fmt.Fprintf(&str, "\tstored\t%s\n", f)
+	}
+	for _, r := range rs {
+		fmt.Fprintf(&str, "\tlink\t%s\t%s\t%s\n", r.from, r.to, r.role)
+	}
+	if _, err := io.WriteString(j.file, str.String()); err != nil {
+		return err
+	}
+	return j.file.Sync()
+}
+
+// Flushed records that the relations of the interrupted runs have been
+// flushed.
+func (j *journal) Flushed() error {
+	if j == nil || (len(j.files) == 0 && len(j.relations) == 0) {
+		return nil
+	}
+	if _, err := io.WriteString(j.file, "\tflushed\n"); err != nil {
+		return err
+	}
+	j.files, j.relations = nil, nil
+	return j.file.Sync()
+}
+
+func (j *journal) Close() error {
+	if j == nil {
+		return nil
+	}
+	return j.file.Close()
+}
+
+func Walk(ctx context.Context, dir string, fn filepath.WalkFunc) error {
+	return filepath.Walk(dir, func(file string, i os.FileInfo, err error) error {
+		if err == nil {
+			err = ctx.Err()
+		}
+		return fn(file, i, err)
+	})
+}
+
+func writeFile(file string, fn func(io.Writer) error) error {
+	if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+		return err
+	}
+	w, err := ioutil.TempFile(filepath.Dir(file), "."+filepath.Base(file)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(w.Name())
+	if err := fn(w); err != nil {
+		w.Close()
+		return err
+	}
+	if err := w.Chmod(0644); err != nil {
+		w.Close()
+		return err
+	}
+	if err := w.Sync(); err != nil {
+		w.Close()
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(w.Name(), file); err != nil {
+		return err
+	}
+	return syncDir(filepath.Dir(file))
+}
+
+func syncDir(dir string) error {
+	f, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return f.Sync()
+}
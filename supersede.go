@@ -0,0 +1,126 @@
+package prospect
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+const (
+	RoleSupersedes   = "supersedes"
+	RoleSupersededBy = "superseded-by"
+)
+
+var versionExp = regexp.MustCompile(`_v(\d+)$`)
+
+// VersionPath returns file with its version suffix (_v<version>) set before
+// its extension, replacing any existing suffix.
+func VersionPath(file string, version int) string {
+	var (
+		ext  = filepath.Ext(file)
+		stem = strings.TrimSuffix(file, ext)
+	)
+	stem = versionExp.ReplaceAllString(stem, "")
+	return fmt.Sprintf("%s_v%d%s", stem, version, ext)
+}
+
+func splitVersion(file string) (string, int) {
+	var (
+		ext  = filepath.Ext(file)
+		stem = strings.TrimSuffix(file, ext)
+		ms   = versionExp.FindStringSubmatch(stem)
+	)
+	if len(ms) == 0 {
+		return file, 1
+	}
+	v, _ := strconv.Atoi(ms[1])
+	return strings.TrimSuffix(stem, ms[0]) + ext, v
+}
+
+// nextVersion gives the version following the highest version of file found
+// in dir. Files without version suffix count as version 1.
+func nextVersion(dir, file string) (int, error) {
+	base, _ := splitVersion(filepath.Base(file))
+	is, err := ioutil.ReadDir(filepath.Join(dir, filepath.Dir(file)))
+	if err != nil {
+		return 0, err
+	}
+	last := 0
+	for _, i := range is {
+		b, v := splitVersion(i.Name())
+		if b == base && v > last {
+			last = v
+		}
+	}
+	return last + 1, nil
+}
+
+// Supersede stores file as a new version of the product found at path (the
+// path of the product relative to the data and metadata directories). The old
+// data file is kept and both metadata documents are linked together. It
+// returns the path of the new version.
+func (a Archive) Supersede(path, file string) (string, error) {
+	old, err := DecodeFile(filepath.Join(a.MetaDir, path+".xml"))
+	if err != nil {
+		return "", err
+	}
+	old.relativeRoot = strings.TrimSuffix(strings.TrimSuffix(old.File, path), "/")
+	for _, k := range old.Links {
+		if k.Role == RoleSupersededBy {
+			return "", fmt.Errorf("%s: already superseded by %s", path, k.File)
+		}
+	}
+	version, err := nextVersion(a.DataDir, path)
+	if err != nil {
+		return "", err
+	}
+	// the metadata describing the content of the old file are not kept
+	next := Data{
+		Experiment:   old.Experiment,
+		Level:        old.Level,
+		Source:       old.Source,
+		Type:         old.Type,
+		Model:        old.Model,
+		Crews:        old.Crews,
+		Owner:        old.Owner,
+		Increments:   old.Increments,
+		Mime:         old.Mime,
+		AcqTime:      old.AcqTime,
+		relativeRoot: old.relativeRoot,
+	}
+	if err := ReadFile(&next, file); err != nil {
+		return "", err
+	}
+	next.Links = append(next.Links, CreateLink(path, RoleSupersedes))
+	next.Version = version
+
+	npath := VersionPath(path, version)
+	if err := a.storeLink(next, npath); err != nil {
+		return "", err
+	}
+	if _, _, err := a.storeMeta(next, npath); err != nil {
+		return "", err
+	}
+	old.Links = append(old.Links, CreateLink(npath, RoleSupersededBy))
+	return npath, a.rewriteMeta(old, path)
+}
+
+func (a Archive) rewriteMeta(d Data, file string) error {
+	d.File = file
+	file = filepath.Join(a.MetaDir, file) + ".xml"
+
+	var buf bytes.Buffer
+	if err := EncodeData(&buf, d); err != nil {
+		return err
+	}
+	if old, err := ioutil.ReadFile(file); err == nil {
+		if err := a.logDiff(file, old, buf.Bytes()); err != nil {
+			return err
+		}
+	}
+	return a.write(file, buf.Bytes())
+}
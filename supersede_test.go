@@ -0,0 +1,126 @@
+package prospect
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestVersionPath(t *testing.T) {
+	data := []struct {
+		File    string
+		Version int
+		Want    string
+	}{
+		{File: "a.txt", Version: 2, Want: "a_v2.txt"},
+		{File: "dir/a.txt", Version: 3, Want: "dir/a_v3.txt"},
+		{File: "a_v2.txt", Version: 3, Want: "a_v3.txt"},
+		{File: "a", Version: 2, Want: "a_v2"},
+		{File: "a.tar.gz", Version: 2, Want: "a.tar_v2.gz"},
+	}
+	for _, d := range data {
+		if got := VersionPath(d.File, d.Version); got != d.Want {
+			t.Errorf("%s(%d): want %s, got %s", d.File, d.Version, d.Want, got)
+		}
+		base, version := splitVersion(d.Want)
+		if want, _ := splitVersion(d.File); base != want || version != d.Version {
+			t.Errorf("%s: want %s(%d), got %s(%d)", d.Want, want, d.Version, base, version)
+		}
+	}
+}
+
+func TestLocate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "prospect-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	a := Archive{MetaDir: dir}
+	for file, sum := range map[string]string{"a.txt": "1", "a_v2.txt": "2", "b.txt": "1"} {
+		err := writeFile(filepath.Join(dir, file+".xml"), func(w io.Writer) error {
+			return EncodeData(w, Data{File: file, Sum: sum})
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	data := []struct {
+		Data    Data
+		Want    string
+		Version int
+		Err     error
+	}{
+		{Data: Data{File: "a.txt", Sum: "3"}, Want: "a.txt"},
+		{Data: Data{File: "c.txt", Sum: "3", AutoVersion: true}, Want: "c.txt", Version: 1},
+		{Data: Data{File: "a.txt", Sum: "1", AutoVersion: true}, Want: "a.txt", Version: 1},
+		{Data: Data{File: "a.txt", Sum: "2", AutoVersion: true}, Want: "a_v2.txt", Version: 2},
+		{Data: Data{File: "a.txt", Sum: "3", AutoVersion: true}, Want: "a_v3.txt", Version: 3},
+		{Data: Data{File: "b.txt", Sum: "3", AutoVersion: true}, Want: "b_v2.txt", Version: 2},
+		{Data: Data{File: "a.txt", Sum: "2", Version: 2}, Want: "a_v2.txt", Version: 2},
+		{Data: Data{File: "a.txt", Sum: "3", Version: 4}, Want: "a_v4.txt", Version: 4},
+		{Data: Data{File: "a.txt", Sum: "3", Version: 2}, Err: ErrOverwrite},
+	}
+	for _, d := range data {
+		x, file, err := a.locate(d.Data)
+		if d.Err != nil {
+			if !errors.Is(err, d.Err) {
+				t.Errorf("%s(%s): want %s, got %v", d.Data.File, d.Data.Sum, d.Err, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s(%s): unexpected error: %s", d.Data.File, d.Data.Sum, err)
+			continue
+		}
+		if file != d.Want || x.Path() != d.Want || x.Version != d.Version {
+			t.Errorf("%s(%s): want %s(%d), got %s/%s(%d)", d.Data.File, d.Data.Sum, d.Want, d.Version, file, x.Path(), x.Version)
+		}
+	}
+}
+
+func TestSupersede(t *testing.T) {
+	dir, err := ioutil.TempDir("", "prospect-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	a := Archive{DataDir: filepath.Join(dir, "data"), MetaDir: filepath.Join(dir, "meta")}
+	file := filepath.Join(dir, "a.txt")
+	if err := ioutil.WriteFile(file, []byte("a"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	old := Data{Experiment: "FSL", Type: TypeText, Mime: MimePlain}
+	if err := ReadFile(&old, file); err != nil {
+		t.Fatal(err)
+	}
+	old.Register(ImageWidth, 640)
+	old.Register(FileRecord, 10)
+	if err := a.Store(old); err != nil {
+		t.Fatal(err)
+	}
+	file = filepath.Join(dir, "a_v2.txt")
+	if err := ioutil.WriteFile(file, []byte("a2"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	path, err := a.Supersede("a.txt", file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	next, err := DecodeFile(filepath.Join(a.MetaDir, path+".xml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if next.Experiment != old.Experiment || next.Type != old.Type || next.Size != 2 {
+		t.Errorf("%s: want %s/%s (2 bytes), got %s/%s (%d bytes)", path, old.Experiment, old.Type, next.Experiment, next.Type, next.Size)
+	}
+	for _, p := range next.Parameters {
+		if p.Name == ImageWidth || p.Name == FileRecord {
+			t.Errorf("%s: unexpected parameter %s", path, p.Name)
+		}
+	}
+}
@@ -9,15 +9,18 @@ import (
 	"time"
 )
 
+// FormatDurationISO formats d as an ISO 8601 duration. The fraction of second
+// is given up to the nanosecond (eg: PT0.5S).
 func FormatDurationISO(d time.Duration) string {
 	var (
-		sdt  = uint64(d.Seconds())
+		sdt  = uint64(d / time.Second)
+		frac = uint64(d % time.Second)
 		sec  uint64
 		min  uint64
 		hour uint64
 		day  uint64
 	)
-	if d.Seconds() == 0 {
+	if d == 0 {
 		return "P0S"
 	}
 
@@ -33,7 +36,7 @@ func FormatDurationISO(d time.Duration) string {
 		str = strconv.AppendUint(str, day, 10)
 		str = append(str, 'D')
 	}
-	if hour > 0 || min > 0 || sec > 0 {
+	if hour > 0 || min > 0 || sec > 0 || frac > 0 {
 		str = append(str, 'T')
 	}
 	if hour > 0 {
@@ -44,13 +47,90 @@ func FormatDurationISO(d time.Duration) string {
 		str = strconv.AppendUint(str, min, 10)
 		str = append(str, 'M')
 	}
-	if sec > 0 {
+	if sec > 0 || frac > 0 {
 		str = strconv.AppendUint(str, sec, 10)
+		if frac > 0 {
+			str = append(str, '.')
+			str = append(str, strings.TrimRight(fmt.Sprintf("%09d", frac), "0")...)
+		}
 		str = append(str, 'S')
 	}
 	return string(str)
 }
 
+// ParseDurationISO parses an ISO 8601 duration made of days, hours, minutes
+// and seconds. Only the seconds can have a fraction.
+func ParseDurationISO(str string) (time.Duration, error) {
+	if !strings.HasPrefix(str, "P") || len(str) == 1 || strings.HasSuffix(str, "T") {
+		return 0, fmt.Errorf("%s: invalid duration", str)
+	}
+	var (
+		d   time.Duration
+		num string
+		inT bool
+	)
+	for _, c := range str[1:] {
+		var unit time.Duration
+		switch {
+		case c >= '0' && c <= '9', c == '.':
+			num += string(c)
+			continue
+		case c == 'T' && !inT && num == "":
+			inT = true
+			continue
+		case num == "":
+			return 0, fmt.Errorf("%s: invalid duration", str)
+		case c == 'D' && !inT:
+			unit = 24 * time.Hour
+		case c == 'H' && inT:
+			unit = time.Hour
+		case c == 'M' && inT:
+			unit = time.Minute
+		case c == 'S':
+			unit = time.Second
+		default:
+			return 0, fmt.Errorf("%s: invalid duration", str)
+		}
+		v, err := parseISONumber(num, unit)
+		if err != nil {
+			return 0, fmt.Errorf("%s: invalid duration", str)
+		}
+		d += v
+		num = ""
+	}
+	if num != "" {
+		return 0, fmt.Errorf("%s: invalid duration", str)
+	}
+	return d, nil
+}
+
+func parseISONumber(str string, unit time.Duration) (time.Duration, error) {
+	var frac string
+	if x := strings.IndexByte(str, '.'); x >= 0 {
+		str, frac = str[:x], str[x+1:]
+		if str == "" || frac == "" || unit != time.Second {
+			return 0, strconv.ErrSyntax
+		}
+		if len(frac) > 9 {
+			frac = frac[:9]
+		}
+		frac += strings.Repeat("0", 9-len(frac))
+	}
+	n, err := strconv.ParseUint(str, 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	d := time.Duration(n) * unit
+	if frac != "" {
+		f, err := strconv.ParseUint(frac, 10, 64)
+		if err != nil {
+			return 0, err
+		}
+		d += time.Duration(f)
+	}
+	return d, nil
+}
+
 const (
 	TimeFormatRT       = "rt"
 	TimeFormatHDKLong  = "hadock"
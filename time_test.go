@@ -0,0 +1,82 @@
+package prospect
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDurationISO(t *testing.T) {
+	data := []struct {
+		Input string
+		Want  time.Duration
+	}{
+		{Input: "P0S", Want: 0},
+		{Input: "PT1S", Want: time.Second},
+		{Input: "PT5M", Want: 5 * time.Minute},
+		{Input: "PT1H30M", Want: 90 * time.Minute},
+		{Input: "P1D", Want: 24 * time.Hour},
+		{Input: "P2DT3H4M5S", Want: 51*time.Hour + 4*time.Minute + 5*time.Second},
+		{Input: "P1DT1S", Want: 24*time.Hour + time.Second},
+		{Input: "PT0.5S", Want: 500 * time.Millisecond},
+		{Input: "PT0.000000001S", Want: time.Nanosecond},
+		{Input: "PT1M2.25S", Want: time.Minute + 2250*time.Millisecond},
+		{Input: "P1DT0.1S", Want: 24*time.Hour + 100*time.Millisecond},
+	}
+	for _, d := range data {
+		got, err := ParseDurationISO(d.Input)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", d.Input, err)
+			continue
+		}
+		if got != d.Want {
+			t.Errorf("%s: want %s, got %s", d.Input, d.Want, got)
+		}
+		if str := FormatDurationISO(got); str != d.Input {
+			t.Errorf("%s: format: want %s, got %s", d.Input, d.Input, str)
+		}
+	}
+}
+
+func TestParseDurationISO(t *testing.T) {
+	data := []struct {
+		Input string
+		Want  time.Duration
+		Err   bool
+	}{
+		{Input: "PT0S", Want: 0},
+		{Input: "PT90M", Want: 90 * time.Minute},
+		{Input: "PT36H", Want: 36 * time.Hour},
+		{Input: "PT0.50S", Want: 500 * time.Millisecond},
+		{Input: "PT1.0000000019S", Want: time.Second + time.Nanosecond},
+		{Input: "PT.5S", Err: true},
+		{Input: "PT1.S", Err: true},
+		{Input: "PT0.5M", Err: true},
+		{Input: "PT1.2.3S", Err: true},
+		{Input: "", Err: true},
+		{Input: "P", Err: true},
+		{Input: "PT", Err: true},
+		{Input: "1D", Err: true},
+		{Input: "P1", Err: true},
+		{Input: "P1H", Err: true},
+		{Input: "PT1D", Err: true},
+		{Input: "PTT1H", Err: true},
+		{Input: "PT1X", Err: true},
+		{Input: "PDT", Err: true},
+	}
+	for _, d := range data {
+		got, err := ParseDurationISO(d.Input)
+		if d.Err {
+			if err == nil {
+				t.Errorf("%s: expected error, got %s", d.Input, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", d.Input, err)
+			continue
+		}
+		if got != d.Want {
+			t.Errorf("%s: want %s, got %s", d.Input, d.Want, got)
+		}
+	}
+}